@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/config"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runCarts implements the carts subcommand:
+//
+//	web carts [flags] list
+//	web carts [flags] expire cart-id
+//
+// Expiring a cart ends its hold now, in redis or postgres, so the running server takes it off the
+// dashboards and records the expiry exactly as if its time had run out.
+func runCarts(args []string) error {
+	fs := flag.NewFlagSet("carts", flag.ExitOnError)
+	loader := config.NewLoader(fs, config.SectionDB, config.SectionCarts, config.SectionRedis)
+	open := fs.Bool("open", false, "only list carts that haven't been bought")
+	limit := fs.Int("limit", 50, "how many carts to list")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: web carts [flags] list")
+		fmt.Fprintln(fs.Output(), "       web carts [flags] expire cart-id")
+		fs.PrintDefaults()
+	}
+	cfg, err := loader.Load(args)
+	if err != nil {
+		return err
+	}
+
+	cmd := fs.Arg(0)
+	if !(cmd == "list" && fs.NArg() == 1) && !(cmd == "expire" && fs.NArg() == 2) {
+		fs.Usage()
+		return errors.New("carts: list or expire is required")
+	}
+
+	db, repo, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	live := openCarts(cfg, repo)
+	defer live.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if cmd == "expire" {
+		id := fs.Arg(1)
+		ok, err := live.Expire(ctx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("carts: %s is not live", id)
+		}
+		audit.New(nil, audit.CommandActor, audit.CartExpire).Target("cart", id).Record(repo)
+
+		fmt.Printf("cart %s expired\n", id)
+		return nil
+	}
+
+	carts, err := repo.RecentCarts(*limit, *open)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED\tEVENT\tTOTAL\tAGENT\tSTATUS\tLIVE")
+	for _, c := range carts {
+		status := "open"
+		if c.Bought {
+			status = "bought by " + c.BoughtBy
+		}
+
+		held := "no"
+		if ok, err := live.Exists(ctx, c.ID); err != nil {
+			held = "?"
+		} else if ok {
+			held = "yes"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\t%s\t%s\t%s\n", c.ID, c.CreatedAt.Format(time.RFC3339), c.EventName,
+			c.TicketTotal, c.UserID, status, held)
+	}
+	return w.Flush()
+}
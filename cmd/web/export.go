@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/config"
+	"github.com/SeatSnobAri/seatflipsite/internal/driver"
+	"github.com/SeatSnobAri/seatflipsite/internal/export"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository/dbrepo"
+	"io"
+	"os"
+	"time"
+)
+
+// runExport implements the export subcommand:
+//
+//	web export [flags] carts|purchases
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	loader := config.NewLoader(fs, config.SectionDB)
+	format := fs.String("format", export.FormatCSV, "output format (csv or ndjson)")
+	from := fs.String("from", "", "only rows created on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only rows created on or before this date (YYYY-MM-DD)")
+	state := fs.String("state", "", "cart state (open or bought) or inventory state")
+	event := fs.String("event", "", "event name contains")
+	agent := fs.String("agent", "", "agent user id")
+	team := fs.String("team", "", "agent team")
+	out := fs.String("out", "", "file to write to (default stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: web export [flags] carts|purchases")
+		fs.PrintDefaults()
+	}
+	cfg, err := loader.Load(args)
+	if err != nil {
+		return err
+	}
+
+	kind := fs.Arg(0)
+	if kind != "carts" && kind != "purchases" {
+		fs.Usage()
+		return errors.New("export: carts or purchases is required")
+	}
+
+	if !export.ValidFormat(*format) {
+		return export.ErrUnknownFormat
+	}
+
+	f := models.ExportFilter{
+		State: *state,
+		Event: *event,
+		Agent: *agent,
+		Team:  *team,
+	}
+	if *from != "" {
+		t, err := time.Parse("2006-01-02", *from)
+		if err != nil {
+			return err
+		}
+		f.From = t
+	}
+	if *to != "" {
+		t, err := time.Parse("2006-01-02", *to)
+		if err != nil {
+			return err
+		}
+		f.To = t.AddDate(0, 0, 1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	db, err := driver.ConnectPostgres(cfg.DB.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	repo := dbrepo.NewPostgresRepo(db.SQL, &app)
+	audit.New(nil, audit.CommandActor, audit.Export).Target("export", kind).
+		Change(nil, map[string]any{"format": *format, "filter": f}).Record(repo)
+
+	if kind == "carts" {
+		return export.Carts(context.Background(), repo, w, *format, f)
+	}
+	return export.Purchases(context.Background(), repo, w, *format, f)
+}
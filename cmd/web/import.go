@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/config"
+	"github.com/SeatSnobAri/seatflipsite/internal/driver"
+	"github.com/SeatSnobAri/seatflipsite/internal/importer"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository/dbrepo"
+	"os"
+)
+
+// runImport implements the import subcommand:
+//
+//	web import [flags] purchases.csv
+//
+// It is a dry run unless -commit is given.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	loader := config.NewLoader(fs, config.SectionDB)
+	mapping := fs.String("map", "", "column mapping overrides as field=header,field=header")
+	agent := fs.String("agent", "", "user id to attribute rows without a user_id column to")
+	commit := fs.Bool("commit", false, "write the rows (default is a dry run)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: web import [flags] purchases.csv")
+		fs.PrintDefaults()
+	}
+	cfg, err := loader.Load(args)
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("import: a csv file is required")
+	}
+
+	m, err := importer.ParseMapping(*mapping)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db, err := driver.ConnectPostgres(cfg.DB.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	repo := dbrepo.NewPostgresRepo(db.SQL, &app)
+	result, err := importer.Purchases(repo, file, m, *agent, !*commit)
+	if err != nil {
+		return err
+	}
+	if *commit {
+		audit.New(nil, audit.CommandActor, audit.Import).Target("file", fs.Arg(0)).Change(nil, result).Record(repo)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/SeatSnobAri/seatflipsite/internal/handlers"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// workers tracks the background goroutines: the expiry listener, the outbox and the job pool. They
+// all run on ctx, which is cancelled once the http server has drained.
+var workers = newWorkerGroup()
+
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{ctx: ctx, cancel: cancel}
+}
+
+// start runs fn in the background until the workers are stopped
+func (w *workerGroup) start(name string, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(w.ctx)
+		slog.Info("stopped worker", "worker", name)
+	}()
+}
+
+// stop cancels the workers and waits for them to return, or for ctx to be done
+func (w *workerGroup) stop(ctx context.Context) error {
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("background workers did not stop in time")
+	}
+}
+
+// shutdown stops the application in order, giving up on whatever is left when ctx is done:
+//  1. fail readiness, and keep serving for delay so load balancers notice
+//  2. stop accepting connections and let in-flight requests, like broker calls, finish
+//  3. stop the expiry listener, the outbox and the job pool
+//  4. send the broadcasts and live cart writes still waiting in the outbox
+//  5. send the spans still buffered
+//  6. close the live cart store, then the database pool
+func shutdown(ctx context.Context, srv *http.Server, delay time.Duration) {
+	// fail readiness first and keep serving for a moment, so load balancers move traffic away
+	handlers.StartShutdown()
+	if delay > 0 {
+		slog.Info("failing readiness", "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	slog.Info("waiting for requests to finish")
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("http server didn't shut down cleanly", "err", err)
+	}
+
+	slog.Info("stopping background workers")
+	if err := workers.stop(ctx); err != nil {
+		slog.Error("background workers didn't stop", "err", err)
+	}
+
+	slog.Info("flushing outbox")
+	if err := app.Outbox.Dispatch(ctx); err != nil {
+		slog.Error("can't flush outbox", "err", err)
+	}
+
+	if err := stopTracing(ctx); err != nil {
+		slog.Error("can't flush traces", "err", err)
+	}
+
+	if err := app.Carts.Close(); err != nil {
+		slog.Error("can't close live carts", "err", err)
+	}
+	if err := app.DB.SQL.Close(); err != nil {
+		slog.Error("can't close database", "err", err)
+	}
+	slog.Info("shut down")
+}
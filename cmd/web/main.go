@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"fmt"
 	"github.com/SeatSnobAri/seatflipsite/internal/config"
 	"github.com/SeatSnobAri/seatflipsite/internal/handlers"
 	"github.com/SeatSnobAri/seatflipsite/internal/models"
 	"github.com/alexedwards/scs/v2"
 	"github.com/pusher/pusher-http-go/v5"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -20,10 +27,33 @@ var session *scs.SessionManager
 var preferenceMap map[string]string
 var wsClient pusher.Client
 
+// stopTracing sends the spans still buffered and stops the exporter
+var stopTracing = func(ctx context.Context) error { return nil }
+
 const seatflipVersion = "1.0.0"
+
+// buildCommit is the commit the binary was built from, set at link time with
+//
+//	go build -ldflags "-X main.buildCommit=$(git rev-parse --short HEAD)" ./cmd/web
+var buildCommit string
+
+// maxWorkerPoolSize is how many background jobs run at once
 const maxWorkerPoolSize = 5
+
+// maxJobMaxWorkers is how many jobs of the same kind run at once
 const maxJobMaxWorkers = 5
 
+// subcommands are the operations the binary can run; serve is the default
+var subcommands = map[string]func(args []string) error{
+	"serve":   runServe,
+	"migrate": runMigrate,
+	"users":   runUsers,
+	"tokens":  runTokens,
+	"carts":   runCarts,
+	"export":  runExport,
+	"import":  runImport,
+}
+
 func init() {
 	gob.Register(models.User{})
 	gob.Register(models.GoogleUserResult{})
@@ -32,38 +62,104 @@ func init() {
 
 // main is the application entry point
 func main() {
+	// with no subcommand, or only flags, the server runs as it always has
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	run, ok := subcommands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// commit returns buildCommit, or the revision go build stamped from the checkout, or unknown
+func commit() string {
+	if buildCommit != "" {
+		return buildCommit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// usage lists the subcommands
+func usage() {
+	var names []string
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "usage: web <command> [flags] [args]\n\ncommands: %s\n\n", strings.Join(names, ", "))
+	fmt.Fprintln(os.Stderr, "run web <command> -h for the flags of a command")
+}
+
+// runServe implements the serve subcommand, which runs the web server:
+//
+//	web serve [flags]
+func runServe(args []string) error {
+	// the first interrupt or SIGTERM starts a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// set up application
-	insecurePort, err := setupApp()
+	cfg, err := setupApp(args)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	// close channels & db when application ends
-	defer app.DB.SQL.Close()
-
 	// print info
-	log.Printf("******************************************")
-	log.Printf("** %s\\Seatflip%s v%s built in %s", "\033[31m", "\033[0m", seatflipVersion, runtime.Version())
-	log.Printf("**----------------------------------------")
-	log.Printf("** Running with %d Processors", runtime.NumCPU())
-	log.Printf("** Running on %s", runtime.GOOS)
-	log.Printf("******************************************")
+	slog.Info("seatflip",
+		"version", seatflipVersion,
+		"commit", app.Commit,
+		"go", runtime.Version(),
+		"processors", runtime.NumCPU(),
+		"os", runtime.GOOS)
 
 	// create http server
 	srv := &http.Server{
-		Addr:              *insecurePort,
+		Addr:              cfg.Server.Port,
 		Handler:           routes(),
 		IdleTimeout:       30 * time.Second,
 		ReadTimeout:       10 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      5 * time.Second,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
 	}
 
-	log.Printf("Starting HTTP server on port %s....", *insecurePort)
+	slog.Info("starting http server", "port", cfg.Server.Port)
 
 	// start the server
-	err = srv.ListenAndServe()
-	if err != nil {
-		log.Fatal(err)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
 	}
+
+	// a second signal kills the process straight away
+	stop()
+	slog.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	shutdown(ctx, srv, cfg.Server.ShutdownDelay)
+
+	return nil
 }
@@ -1,9 +1,22 @@
 package main
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/metrics"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -23,12 +36,153 @@ func Auth(next http.Handler) http.Handler {
 			http.Redirect(w, r, fmt.Sprintf("/?target=%s", url), http.StatusFound)
 			return
 		}
+		// a user who was disabled after signing in loses their session on the next request
+		user, err := repo.DB.GetUserById(session.GetString(r.Context(), "user_id"))
+		if err != nil || !user.Active() {
+			_ = session.Destroy(r.Context())
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
 		w.Header().Add("Cache-Control", "no-store")
 
+		// everything logged for the rest of the request says whose it was
+		logger := logging.FromContext(r.Context()).With("user_id", user.ID)
+		next.ServeHTTP(w, r.WithContext(logging.NewContext(r.Context(), logger)))
+	})
+}
+
+// Admin checks that the user has admin access
+func Admin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := repo.DB.GetUserById(session.GetString(r.Context(), "user_id"))
+		if err != nil || user.AccessLevel < models.AccessAdmin {
+			session.Put(r.Context(), "error", "You need admin access for that page")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Buyer checks that the user has at least buyer access
+func Buyer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := repo.DB.GetUserById(session.GetString(r.Context(), "user_id"))
+		if err != nil || user.AccessLevel < models.AccessBuyer {
+			session.Put(r.Context(), "error", "You need buyer access for that page")
+			http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// MetricsAuth lets only scrapers with the metrics token through, and hides the page without one
+func MetricsAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := app.MetricsToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(helpers.BearerToken(r)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Tracing starts a span for every request, continuing the trace the extension sent in its
+// traceparent header if there is one. The span is named for the route once chi has matched it.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+				semconv.ClientAddress(r.RemoteAddr)))
+		defer span.End()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if route := chi.RouteContext(r.Context()).RoutePattern(); route != "" {
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// RequestLogger gives each request an id, taken from the X-Request-ID header when a proxy in front
+// of us set one, and a logger tagged with it, then logs the request once it has been answered
+func RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id)
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger = logger.With("trace_id", sc.TraceID().String())
+		}
+		ctx := logging.WithRequestID(r.Context(), id)
+		ctx = logging.NewContext(ctx, logger)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		// probes and scrapes come every few seconds, so they are only logged at debug
+		level := slog.LevelInfo
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			level = slog.LevelDebug
+		}
+		logger.Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr)
+	})
+}
+
+// Metrics times every request by its route pattern
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		// the pattern is only known once chi has routed the request
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.ObserveRequest(r.Method, route, ww.Status(), time.Since(start))
+	})
+}
+
 // RecoverPanic recovers from a panic
 func RecoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
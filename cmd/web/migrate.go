@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/config"
+	"github.com/SeatSnobAri/seatflipsite/internal/driver"
+	"github.com/SeatSnobAri/seatflipsite/internal/migrate"
+	"github.com/SeatSnobAri/seatflipsite/migrations"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runMigrate implements the migrate subcommand:
+//
+//	web migrate [flags] up|down|status
+//	web migrate [flags] create name
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	loader := config.NewLoader(fs, config.SectionDB)
+	steps := fs.Int("steps", 1, "how many migrations down reverts")
+	dir := fs.String("dir", "migrations", "directory create writes new migrations to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: web migrate [flags] up|down|status")
+		fmt.Fprintln(fs.Output(), "       web migrate [flags] create name")
+		fs.PrintDefaults()
+	}
+	cfg, err := loader.Load(args)
+	if err != nil {
+		return err
+	}
+
+	cmd := fs.Arg(0)
+	if cmd == "create" {
+		if fs.NArg() != 2 {
+			fs.Usage()
+			return errors.New("migrate: create needs a name")
+		}
+		up, down, err := migrate.Create(*dir, fs.Arg(1), time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Println("created", up)
+		fmt.Println("created", down)
+		return nil
+	}
+	if cmd != "up" && cmd != "down" && cmd != "status" {
+		fs.Usage()
+		return errors.New("migrate: up, down, status or create is required")
+	}
+
+	db, err := driver.ConnectPostgres(cfg.DB.DSN())
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	runner, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch cmd {
+	case "up":
+		done, err := runner.Up(ctx)
+		for _, m := range done {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return err
+	case "down":
+		done, err := runner.Down(ctx, *steps)
+		for _, m := range done {
+			fmt.Printf("reverted %d_%s\n", m.Version, m.Name)
+		}
+		return err
+	default:
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	}
+}
@@ -4,18 +4,29 @@ import (
 	"github.com/SeatSnobAri/seatflipsite/internal/handlers"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
 )
 
 func routes() http.Handler {
+	// probes skip the session, csrf and remember me middleware, which would touch the database
+	root := chi.NewRouter()
+	root.Use(Tracing)
+	root.Use(RequestLogger)
+	root.Use(Metrics)
+	root.With(MetricsAuth).Handle("/metrics", promhttp.Handler())
+	root.Get("/healthz", handlers.Repo.Healthz)
+	root.Get("/readyz", handlers.Repo.Readyz)
+	root.Get("/version", handlers.Repo.Version)
 
 	mux := chi.NewRouter()
+	root.Mount("/", mux)
 
 	// default middleware
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "traceparent", "tracestate"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
@@ -51,6 +62,62 @@ func routes() http.Handler {
 		mux.Use(Auth)
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
 		mux.Get("/private-message", handlers.Repo.SendPrivateMessage)
+
+		mux.Get("/inventory", handlers.Repo.AdminInventory)
+		mux.With(Buyer).Post("/inventory/{id}", handlers.Repo.PostInventoryState)
+
+		mux.Get("/reports", handlers.Repo.AdminReports)
+		mux.Get("/reports/events", handlers.Repo.EventReportJSON)
+		mux.Get("/reports/agents", handlers.Repo.AgentReportJSON)
+		mux.Get("/reports/teams", handlers.Repo.TeamReportJSON)
+		mux.Get("/reports/periods", handlers.Repo.PeriodReportJSON)
+
+		// exports, their jobs and imports read and write everyone's carts
+		mux.Group(func(mux chi.Router) {
+			mux.Use(Admin)
+			mux.Get("/export/carts", handlers.Repo.ExportCarts)
+			mux.Get("/export/purchases", handlers.Repo.ExportPurchases)
+
+			mux.Get("/jobs", handlers.Repo.AdminJobs)
+			mux.Post("/jobs/export", handlers.Repo.PostExportJob)
+			mux.Get("/jobs/{id}/download", handlers.Repo.JobDownload)
+
+			mux.Get("/import", handlers.Repo.AdminImport)
+			mux.Post("/import", handlers.Repo.PostAdminImport)
+		})
+
+		mux.Get("/rules", handlers.Repo.AdminRules)
+		mux.Post("/rules", handlers.Repo.PostRule)
+		mux.Post("/rules/{id}/enabled", handlers.Repo.ToggleRule)
+		mux.Post("/rules/{id}/delete", handlers.Repo.DeleteRule)
+
+		mux.Get("/orders", handlers.Repo.AdminOrders)
+		mux.Post("/orders", handlers.Repo.PostOrder)
+		mux.Get("/orders/{id}", handlers.Repo.EditOrder)
+		mux.Post("/orders/{id}", handlers.Repo.PostOrder)
+		mux.Post("/orders/{id}/cancel", handlers.Repo.CancelOrder)
+
+		mux.Get("/replay", handlers.Repo.AdminReplay)
+
+		mux.Get("/confirmations", handlers.Repo.AdminConfirmations)
+
+		mux.Post("/attachments", handlers.Repo.PostAttachment)
+		mux.Get("/attachments/{id}", handlers.Repo.Attachment)
+
+		mux.Route("/audit", func(mux chi.Router) {
+			mux.Use(Admin)
+			mux.Get("/", handlers.Repo.AdminAudit)
+			mux.Get("/export", handlers.Repo.ExportAudit)
+		})
+
+		mux.Route("/budgets", func(mux chi.Router) {
+			mux.Use(Admin)
+			mux.Get("/", handlers.Repo.AdminBudgets)
+			mux.Post("/", handlers.Repo.PostBudget)
+			mux.Post("/{id}/delete", handlers.Repo.DeleteBudget)
+			mux.Post("/overrides/{id}/approve", handlers.Repo.ApproveOverride)
+			mux.Post("/overrides/{id}/decline", handlers.Repo.DeclineOverride)
+		})
 		//mux.Get("/current-redis", handlers.Repo.endCurrentRedis)
 
 	})
@@ -59,5 +126,5 @@ func routes() http.Handler {
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
-	return mux
+	return root
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/migrate"
+	"github.com/SeatSnobAri/seatflipsite/migrations"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDatabaseEnv names the variable holding the url of a Postgres server the tests may create
+// databases on. Without it the tests are skipped.
+const testDatabaseEnv = "SEATFLIP_TEST_DATABASE_URL"
+
+// newTestDatabase creates a migrated database that is dropped when the test ends and returns its
+// config, for opening a pool per instance
+func newTestDatabase(t *testing.T) pgx.ConnConfig {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := stdlib.OpenDB(*config)
+	t.Cleanup(func() { server.Close() })
+
+	name := fmt.Sprintf("seatflip_test_%d", time.Now().UnixNano())
+	if _, err = server.Exec(`create database ` + name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := server.Exec(`drop database if exists ` + name + ` with (force)`); err != nil {
+			t.Error(err)
+		}
+	})
+
+	config.Database = name
+	db := openPool(t, *config)
+	runner, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = runner.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return *config
+}
+
+// openPool opens a connection pool of its own, as each instance has
+func openPool(t *testing.T, config pgx.ConnConfig) *sql.DB {
+	db := stdlib.OpenDB(config)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestSessionSharedByInstances signs in on one instance and checks the next request, sent to
+// another instance behind the load balancer, sees the same session
+func TestSessionSharedByInstances(t *testing.T) {
+	config := newTestDatabase(t)
+	first := newSessionManager(openPool(t, config), "test", false)
+	second := newSessionManager(openPool(t, config), "test", false)
+
+	signIn := first.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first.Put(r.Context(), "user_id", "ann")
+	}))
+	rec := httptest.NewRecorder()
+	signIn.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("signing in set no session cookie")
+	}
+
+	var userID string
+	whoami := second.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = second.GetString(r.Context(), "user_id")
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	whoami.ServeHTTP(httptest.NewRecorder(), req)
+
+	if userID != "ann" {
+		t.Errorf("second instance read user_id %q, want %q", userID, "ann")
+	}
+}
@@ -2,156 +2,223 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"flag"
 	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
 	"github.com/SeatSnobAri/seatflipsite/internal/config"
 	"github.com/SeatSnobAri/seatflipsite/internal/driver"
 	"github.com/SeatSnobAri/seatflipsite/internal/handlers"
 	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/jobs"
+	"github.com/SeatSnobAri/seatflipsite/internal/leader"
+	"github.com/SeatSnobAri/seatflipsite/internal/livecarts"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/metrics"
+	"github.com/SeatSnobAri/seatflipsite/internal/migrate"
+	"github.com/SeatSnobAri/seatflipsite/internal/outbox"
 	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository/dbrepo"
+	"github.com/SeatSnobAri/seatflipsite/internal/storage"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	"github.com/SeatSnobAri/seatflipsite/migrations"
 	"github.com/alexedwards/scs/postgresstore"
 	"github.com/alexedwards/scs/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/pusher/pusher-http-go/v5"
 	"github.com/redis/go-redis/v9"
-	"log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
 )
 
-func setupApp() (*string, error) {
-	// read flags
-	insecurePort := flag.String("port", ":4000", "port to listen on")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	identifier := flag.String("identifier", "seatflip", "unique identifier")
-	domain := flag.String("domain", "localhost", "domain name (e.g. example.com)")
-	inProduction := flag.Bool("production", false, "application is in production")
-	dbHost := flag.String("dbhost", "localhost", "database host")
-	dbPort := flag.String("dbport", "5432", "database port")
-	dbUser := flag.String("dbuser", "postgres", "database user")
-	dbPass := flag.String("dbpass", "", "database password")
-	databaseName := flag.String("db", "seatflip", "database name")
-	dbSsl := flag.String("dbssl", "disable", "database ssl setting")
-	pusherHost := flag.String("pusherHost", "", "pusher host")
-	pusherPort := flag.String("pusherPort", "443", "pusher port")
-	pusherApp := flag.String("pusherApp", "1618595", "pusher app id")
-	pusherKey := flag.String("pusherKey", "ae30ade191f5e0f49b84", "pusher key")
-	pusherSecret := flag.String("pusherSecret", "48c39a2ae19f52ac26f8", "pusher secret")
-	pusherSecure := flag.Bool("pusherSecure", true, "pusher server uses SSL (true or false)")
-
-	flag.Parse()
-
-	if *dbUser == "" || *dbHost == "" || *dbPort == "" || *databaseName == "" || *identifier == "" {
-		fmt.Println("Missing required flags.")
-		os.Exit(1)
+func setupApp(args []string) (*config.Settings, error) {
+	// read settings from the config file, environment and flags
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	loader := config.NewLoader(fs, config.SectionServer, config.SectionDB, config.SectionCarts,
+		config.SectionRedis, config.SectionPusher, config.SectionGoogle, config.SectionTracing)
+	cfg, err := loader.Load(args)
+	if err != nil {
+		return nil, err
 	}
-	app.UseCache = *useCache
-	log.Println("Connecting to database....")
-	dsnString := ""
-
-	// when developing locally, we often don't have a db password
-	if *dbPass == "" {
-		dsnString = fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s timezone=UTC connect_timeout=5",
-			*dbHost,
-			*dbPort,
-			*dbUser,
-			*databaseName,
-			*dbSsl)
-	} else {
-		dsnString = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s timezone=UTC connect_timeout=5",
-			*dbHost,
-			*dbPort,
-			*dbUser,
-			*dbPass,
-			*databaseName,
-			*dbSsl)
+
+	// JSON for the log collector in production, text for people in development
+	slog.SetDefault(logging.New(os.Stderr, cfg.Server.InProduction, cfg.Server.LogLevel))
+	slog.Info("settings", "env", cfg.Env, "settings", *cfg)
+
+	// only the load balancer may say where a request came from
+	proxies, err := audit.ParseProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
 	}
+	audit.TrustProxies(proxies)
+
+	stopTracing, err = tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Version:     seatflipVersion,
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("tracing", "exporter", cfg.Tracing.Exporter)
+
+	app.UseCache = cfg.Server.UseCache
+	slog.Info("connecting to database")
 
-	db, err := driver.ConnectPostgres(dsnString)
+	db, err := driver.ConnectPostgres(cfg.DB.DSN())
 	if err != nil {
-		log.Fatal("Cannot connect to database!", err)
+		return nil, fmt.Errorf("cannot connect to database: %w", err)
 	}
 
+	// refuse to start against a schema this code doesn't match
+	runner, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load migrations: %w", err)
+	}
+	if err = runner.Check(context.Background()); err != nil {
+		return nil, fmt.Errorf("%w (run web migrate up)", err)
+	}
+
+	store, err := storage.NewDisk(cfg.Server.StoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open attachment storage: %w", err)
+	}
+
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db.SQL, "seatflip"))
+
 	// session
-	log.Printf("Initializing session manager....")
-	session = scs.New()
-	session.Store = postgresstore.New(db.SQL)
-	session.Lifetime = 24 * time.Hour
-	session.Cookie.Persist = true
-	session.Cookie.Name = fmt.Sprintf("gbsession_id_%s", *identifier)
-	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = *inProduction
+	slog.Info("initializing session manager")
+	session = newSessionManager(db.SQL, cfg.Server.Identifier, cfg.Server.InProduction)
 
 	// define application configuration
 	a := config.AppConfig{
-		DB:           db,
-		Session:      session,
-		InProduction: *inProduction,
-		Domain:       *domain,
-		PusherSecret: *pusherSecret,
-		Version:      seatflipVersion,
-		Identifier:   *identifier,
+		DB:                db,
+		Session:           session,
+		InProduction:      cfg.Server.InProduction,
+		Domain:            cfg.Server.Domain,
+		PusherSecret:      cfg.Pusher.Secret.Value(),
+		MetricsToken:      cfg.Server.MetricsToken.Value(),
+		Version:           seatflipVersion,
+		Commit:            commit(),
+		Identifier:        cfg.Server.Identifier,
+		ApprovalThreshold: cfg.Server.ApprovalThreshold,
+		Storage:           store,
+		GoogleOAuth: &oauth2.Config{
+			RedirectURL:  cfg.Google.RedirectURL,
+			ClientID:     cfg.Google.ClientID,
+			ClientSecret: cfg.Google.ClientSecret.Value(),
+			Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email"},
+			Endpoint:     google.Endpoint,
+		},
 	}
 
 	app = a
 
-	log.Println("Getting preferences...")
 	preferenceMap = make(map[string]string)
 
-	preferenceMap["pusher-host"] = *pusherHost
-	preferenceMap["pusher-port"] = *pusherPort
-	preferenceMap["pusher-key"] = *pusherKey
-	preferenceMap["identifier"] = *identifier
+	preferenceMap["pusher-host"] = cfg.Pusher.Host
+	preferenceMap["pusher-port"] = cfg.Pusher.Port
+	preferenceMap["pusher-key"] = cfg.Pusher.Key
+	preferenceMap["identifier"] = cfg.Server.Identifier
 	preferenceMap["version"] = seatflipVersion
 
 	app.PreferenceMap = preferenceMap
 
 	// create pusher client
 	wsClient = pusher.Client{
-		AppID:   *pusherApp,
-		Key:     *pusherKey,
-		Secret:  *pusherSecret,
-		Cluster: "mt1",
-		Secure:  true,
+		AppID:   cfg.Pusher.AppID,
+		Key:     cfg.Pusher.Key,
+		Secret:  cfg.Pusher.Secret.Value(),
+		Cluster: cfg.Pusher.Cluster,
+		Secure:  cfg.Pusher.Secure,
+		HTTPClient: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: metrics.Transport(metrics.Pusher, http.DefaultTransport),
+		},
 	}
 
-	log.Println("Host", fmt.Sprintf("%s:%s", *pusherHost, *pusherPort))
-	log.Println("Secure", *pusherSecure)
+	slog.Info("pusher", "host", fmt.Sprintf("%s:%s", cfg.Pusher.Host, cfg.Pusher.Port), "secure", cfg.Pusher.Secure)
 
 	app.WsClient = wsClient
 
-	redis := redis.NewClient(&redis.Options{
-		Addr:     ":6379",
-		Password: "",
-		DB:       0,
-	})
-	_, err = redis.Do(context.Background(), "CONFIG", "SET", "notify-keyspace-events", "KEA").Result() // this is telling redis to publish events since it's off by default.
-	if err != nil {
-		fmt.Printf("unable to set keyspace events %v", err.Error())
-		os.Exit(1)
-	}
-	//pubsub := redis.PSubscribe(context.Background(), "__keyevent@0__:expired") // this is telling redis to subscribe to events published in the keyevent channel, specifically for expired events
-	pubsub := redis.PSubscribe(context.Background(), "__keyevent@0__:expired") // this is telling redis to subscribe to events published in the keyevent channel, specifically for expired events
-	app.Redis = redis
-
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Println(err)
-		log.Fatal("cannot create template cache")
-		return nil, err
+		return nil, fmt.Errorf("cannot create template cache: %w", err)
 	}
 
 	app.TemplateCache = tc
 
 	repo = handlers.NewPostgresqlHandlers(db, &app)
 	handlers.NewHandlers(repo, &app)
+
+	slog.Info("holding live carts", "store", cfg.Carts.Store)
+	app.Carts = openCarts(cfg, repo.DB)
+	if s, ok := app.Carts.(*livecarts.Redis); ok {
+		if cfg.Redis.KeyspaceEvents {
+			// this is telling redis to publish events since it's off by default.
+			err = s.ConfigureKeyspaceEvents(context.Background())
+			if err != nil {
+				return nil, fmt.Errorf("unable to set keyspace events: %w (turn on Ex notifications on the server and run with -redisKeyspaceEvents=false)", err)
+			}
+		} else {
+			slog.Info("not configuring redis keyspace events; expiries rely on the server's notify-keyspace-events and the sweep")
+		}
+	}
+
+	// live cart writes and broadcasts that follow database writes go through the outbox
+	app.Outbox = outbox.New(repo.DB, app.Carts, &app.WsClient)
+	workers.start("outbox", app.Outbox.Run)
+
+	// slow and periodic work runs on the job pool instead of in request handlers
+	app.Jobs = jobs.New(repo.DB, maxWorkerPoolSize, maxJobMaxWorkers)
+	if err = repo.RegisterJobs(app.Jobs); err != nil {
+		return nil, fmt.Errorf("cannot register jobs: %w", err)
+	}
+	workers.start("job pool", app.Jobs.Run)
 	render.NewRenderer(&app)
 
 	helpers.NewHelpers(&app)
 
-	go handlers.Repo.RedisExpiry(pubsub)
+	// every instance hears every expiry, so only the leader watches and each is broadcast once
+	app.ExpiryLeader = leader.New(db.SQL, "cart expiries")
+	workers.start("expiry listener", func(ctx context.Context) {
+		app.ExpiryLeader.Run(ctx, handlers.Repo.WatchExpiries)
+	})
 
-	return insecurePort, err
+	return cfg, err
+}
+
+// openCarts opens the live cart store the settings choose
+func openCarts(cfg *config.Settings, db repository.DatabaseRepo) livecarts.Store {
+	if cfg.Carts.Store == livecarts.BackendPostgres {
+		return livecarts.NewPostgres(db)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password.Value(),
+		DB:       cfg.Redis.DB,
+	})
+	client.AddHook(metrics.RedisHook{})
+	client.AddHook(tracing.RedisHook{})
+	return livecarts.NewRedis(client, cfg.Redis.DB)
+}
+
+// connect opens the database for a subcommand; the caller closes it
+func connect(cfg *config.Settings) (*driver.DB, repository.DatabaseRepo, error) {
+	db, err := driver.ConnectPostgres(cfg.DB.DSN())
+	if err != nil {
+		return nil, nil, err
+	}
+	return db, dbrepo.NewPostgresRepo(db.SQL, &app), nil
 }
 
 // createDirIfNotExist creates a directory if it does not exist
@@ -160,9 +227,22 @@ func createDirIfNotExist(path string) error {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		err = os.Mkdir(path, mode)
 		if err != nil {
-			log.Println(err)
 			return err
 		}
 	}
 	return nil
 }
+
+// newSessionManager returns a session manager that keeps sessions in Postgres, so every instance
+// sharing db can read the sessions the others write
+func newSessionManager(db *sql.DB, identifier string, secure bool) *scs.SessionManager {
+	s := scs.New()
+	// expired sessions are deleted by a scheduled job rather than a goroutine per instance
+	s.Store = postgresstore.NewWithCleanupInterval(db, 0)
+	s.Lifetime = 24 * time.Hour
+	s.Cookie.Persist = true
+	s.Cookie.Name = fmt.Sprintf("gbsession_id_%s", identifier)
+	s.Cookie.SameSite = http.SameSiteLaxMode
+	s.Cookie.Secure = secure
+	return s
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/config"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// runTokens implements the tokens subcommand, which manages the api tokens the browser extension
+// can call the broker with:
+//
+//	web tokens [flags] list
+//	web tokens [flags] create user-id
+//	web tokens [flags] revoke token-id
+func runTokens(args []string) error {
+	fs := flag.NewFlagSet("tokens", flag.ExitOnError)
+	loader := config.NewLoader(fs, config.SectionDB)
+	name := fs.String("name", "", "what the token is for, e.g. the agent's laptop")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: web tokens [flags] list")
+		fmt.Fprintln(fs.Output(), "       web tokens [flags] create user-id")
+		fmt.Fprintln(fs.Output(), "       web tokens [flags] revoke token-id")
+		fs.PrintDefaults()
+	}
+	cfg, err := loader.Load(args)
+	if err != nil {
+		return err
+	}
+
+	cmd := fs.Arg(0)
+	if !(cmd == "list" && fs.NArg() == 1) && !((cmd == "create" || cmd == "revoke") && fs.NArg() == 2) {
+		fs.Usage()
+		return errors.New("tokens: list, create or revoke is required")
+	}
+
+	db, repo, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	switch cmd {
+	case "list":
+		tokens, err := repo.AllAPITokens()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tNAME\tCREATED\tLAST USED\tREVOKED")
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.UserID, t.Name, t.CreatedAt.Format(time.RFC3339),
+				formatTime(t.LastUsedAt), formatTime(t.RevokedAt))
+		}
+		return w.Flush()
+
+	case "create":
+		user, err := repo.GetUserById(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("tokens: no user %s", fs.Arg(1))
+		}
+		if !user.Active() {
+			return fmt.Errorf("tokens: user %s is %s", user.ID, user.Status)
+		}
+
+		token, hash, err := helpers.NewAPIToken()
+		if err != nil {
+			return err
+		}
+		id, err := repo.InsertAPIToken(models.APIToken{UserID: user.ID, Name: *name, TokenHash: hash})
+		if err != nil {
+			return err
+		}
+		audit.New(nil, audit.CommandActor, audit.TokenCreate).Target("token", strconv.Itoa(id)).
+			Change(nil, map[string]string{"user_id": user.ID, "name": *name}).Record(repo)
+
+		fmt.Printf("token %d for %s (it is only shown once):\n%s\n", id, user.ID, token)
+		return nil
+
+	default:
+		id, err := strconv.Atoi(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("tokens: %q is not a token id", fs.Arg(1))
+		}
+		if err = repo.RevokeAPIToken(id); errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("tokens: no unrevoked token %d", id)
+		} else if err != nil {
+			return err
+		}
+		audit.New(nil, audit.CommandActor, audit.TokenRevoke).Target("token", strconv.Itoa(id)).Record(repo)
+
+		fmt.Printf("token %d revoked\n", id)
+		return nil
+	}
+}
+
+// formatTime formats t for command output, leaving the zero time blank
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/config"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"os"
+	"text/tabwriter"
+)
+
+// runUsers implements the users subcommand:
+//
+//	web users [flags] list
+//	web users [flags] approve|disable user-id
+//	web users [flags] set-role user-id agent|buyer|admin
+func runUsers(args []string) error {
+	fs := flag.NewFlagSet("users", flag.ExitOnError)
+	loader := config.NewLoader(fs, config.SectionDB)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: web users [flags] list")
+		fmt.Fprintln(fs.Output(), "       web users [flags] approve|disable user-id")
+		fmt.Fprintln(fs.Output(), "       web users [flags] set-role user-id agent|buyer|admin")
+		fs.PrintDefaults()
+	}
+	cfg, err := loader.Load(args)
+	if err != nil {
+		return err
+	}
+
+	cmd := fs.Arg(0)
+	switch {
+	case cmd == "list" && fs.NArg() == 1:
+	case (cmd == "approve" || cmd == "disable") && fs.NArg() == 2:
+	case cmd == "set-role" && fs.NArg() == 3:
+		if _, ok := models.AccessLevels[fs.Arg(2)]; !ok {
+			return fmt.Errorf("users: unknown role %q", fs.Arg(2))
+		}
+	default:
+		fs.Usage()
+		return errors.New("users: list, approve, disable or set-role is required")
+	}
+
+	db, repo, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.SQL.Close()
+
+	if cmd == "list" {
+		users, err := repo.AllUsers()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tEMAIL\tTEAM\tROLE\tSTATUS")
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s %s\t%s\t%s\t%s\t%s\n", u.ID, u.FirstName, u.LastName, u.Email, u.Team, u.Role(), u.Status)
+		}
+		return w.Flush()
+	}
+
+	user, err := repo.GetUserById(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("users: no user %s", fs.Arg(1))
+	}
+
+	if cmd == "set-role" {
+		role := fs.Arg(2)
+		if err = repo.SetUserAccessLevel(user.ID, models.AccessLevels[role]); err != nil {
+			return err
+		}
+		audit.New(nil, audit.CommandActor, audit.RoleChange).Target("user", user.ID).
+			Change(map[string]string{"role": user.Role()}, map[string]string{"role": role}).Record(repo)
+		fmt.Printf("%s is now %s\n", user.ID, role)
+		return nil
+	}
+
+	status := models.UserActive
+	if cmd == "disable" {
+		status = models.UserDisabled
+	}
+	if err = repo.SetUserStatus(user.ID, status); err != nil {
+		return err
+	}
+	audit.New(nil, audit.CommandActor, audit.StatusChange).Target("user", user.ID).
+		Change(map[string]string{"status": user.Status}, map[string]string{"status": status}).Record(repo)
+	fmt.Printf("%s is now %s\n", user.ID, status)
+	return nil
+}
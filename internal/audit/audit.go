@@ -0,0 +1,200 @@
+// Package audit writes the append-only log of sensitive actions: who did what to which record,
+// what it looked like before and after, and where the request came from.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// audited actions
+const (
+	Login         = "login"
+	LoginFailed   = "login.failed"
+	Logout        = "logout"
+	SignUp        = "signup"
+	Produce       = "cart.produce"
+	Buy           = "cart.buy"
+	Claim         = "cart.claim"
+	AutoBuy       = "cart.auto_buy"
+	Confirm       = "cart.confirm"
+	Override      = "budget.override"
+	BudgetSave    = "budget.save"
+	BudgetDelete  = "budget.delete"
+	RuleSave      = "rule.save"
+	RuleDelete    = "rule.delete"
+	OrderSave     = "order.save"
+	OrderCancel   = "order.cancel"
+	InventoryEdit = "inventory.edit"
+	AttachmentAdd = "attachment.add"
+	TokenCreate   = "token.create"
+	TokenRevoke   = "token.revoke"
+	RoleChange    = "user.role"
+	StatusChange  = "user.status"
+	CartExpire    = "cart.expire"
+	Export        = "export"
+	Import        = "import"
+	AuditExport   = "audit.export"
+)
+
+// actors that aren't a signed in user
+const (
+	// SystemActor is the server acting on its own, such as rules auto-approving a cart
+	SystemActor = "system"
+	// CommandActor is someone running a command line tool against the database
+	CommandActor = "cli"
+	// UnknownActor is a request from someone who isn't signed in
+	UnknownActor = "anonymous"
+)
+
+// longest user agent kept on an event
+const maxUserAgent = 512
+
+// Actions lists every audited action, for filtering the log
+var Actions = []string{
+	Login, LoginFailed, Logout, SignUp, Produce, Buy, Claim, AutoBuy, Confirm, Override, BudgetSave,
+	BudgetDelete, RuleSave, RuleDelete, OrderSave, OrderCancel, InventoryEdit, AttachmentAdd, TokenCreate,
+	TokenRevoke, RoleChange, StatusChange, CartExpire, Export, Import, AuditExport,
+}
+
+// Recorder is where events are written; the database repository is one
+type Recorder interface {
+	InsertAuditEvent(e models.AuditEvent) error
+}
+
+// Event is an audit event being built up before it is recorded
+type Event struct {
+	e models.AuditEvent
+}
+
+// New starts an event for actor doing action. When r is not nil the event carries the request's
+// client address, user agent, method and path.
+func New(r *http.Request, actor, action string) *Event {
+	if actor == "" {
+		actor = UnknownActor
+	}
+
+	ev := &Event{e: models.AuditEvent{Actor: actor, Action: action}}
+	if r != nil {
+		ev.e.IP = clientIP(r)
+		ev.e.UserAgent = r.UserAgent()
+		if len(ev.e.UserAgent) > maxUserAgent {
+			ev.e.UserAgent = ev.e.UserAgent[:maxUserAgent]
+		}
+		ev.e.Method = r.Method
+		ev.e.Path = r.URL.Path
+	}
+	return ev
+}
+
+// Target sets the record the action was done to
+func (ev *Event) Target(targetType, id string) *Event {
+	ev.e.TargetType = targetType
+	ev.e.TargetID = id
+	return ev
+}
+
+// Change sets the state of the target before and after the action. Either may be nil.
+func (ev *Event) Change(before, after any) *Event {
+	ev.e.Before = encode(before)
+	ev.e.After = encode(after)
+	return ev
+}
+
+// Record writes the event. The action has already happened by the time it is audited, so a
+// failure to write is logged rather than returned.
+func (ev *Event) Record(rec Recorder) {
+	err := rec.InsertAuditEvent(ev.e)
+	if err != nil {
+		slog.Error("audit: can't record event", "action", ev.e.Action, "actor", ev.e.Actor, "err", err)
+	}
+}
+
+func encode(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("audit: can't encode change", "err", err)
+		return nil
+	}
+	return b
+}
+
+// trustedProxies are the proxies whose X-Forwarded-For header is believed
+var trustedProxies []netip.Prefix
+
+// ParseProxies reads a comma separated list of proxy addresses and CIDR ranges
+func ParseProxies(list string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("audit: trusted proxy %q is not an address or CIDR range", s)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("audit: trusted proxy %q is not an address or CIDR range", s)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// TrustProxies sets the proxies, such as the load balancer, whose X-Forwarded-For header is used for
+// the address an event came from. From anyone else the header is ignored, so it can't be forged.
+func TrustProxies(proxies []netip.Prefix) {
+	trustedProxies = proxies
+}
+
+// trusted reports whether addr is a trusted proxy
+func trusted(addr netip.Addr) bool {
+	for _, p := range trustedProxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address the request came from. When that is a trusted proxy, the forwarded
+// addresses are walked back from the nearest hop to the first one that isn't a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !trusted(addr) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// anything before a hop we can't read may have been made up
+			break
+		}
+		addr = hop
+		if !trusted(hop) {
+			break
+		}
+	}
+	return addr.Unmap().String()
+}
@@ -2,24 +2,37 @@ package config
 
 import (
 	"github.com/SeatSnobAri/seatflipsite/internal/driver"
+	"github.com/SeatSnobAri/seatflipsite/internal/jobs"
+	"github.com/SeatSnobAri/seatflipsite/internal/leader"
+	"github.com/SeatSnobAri/seatflipsite/internal/livecarts"
+	"github.com/SeatSnobAri/seatflipsite/internal/outbox"
+	"github.com/SeatSnobAri/seatflipsite/internal/storage"
 	"github.com/alexedwards/scs/v2"
 	"github.com/pusher/pusher-http-go/v5"
-	"github.com/redis/go-redis/v9"
+	"golang.org/x/oauth2"
 	"html/template"
 )
 
 // AppConfig holds application configuration
 type AppConfig struct {
-	UseCache      bool
-	DB            *driver.DB
-	Session       *scs.SessionManager
-	InProduction  bool
-	Domain        string
-	PreferenceMap map[string]string
-	Redis         *redis.Client
-	WsClient      pusher.Client
-	PusherSecret  string
-	TemplateCache map[string]*template.Template
-	Version       string
-	Identifier    string
+	UseCache          bool
+	DB                *driver.DB
+	Session           *scs.SessionManager
+	InProduction      bool
+	Domain            string
+	PreferenceMap     map[string]string
+	Carts             livecarts.Store
+	WsClient          pusher.Client
+	PusherSecret      string
+	MetricsToken      string
+	TemplateCache     map[string]*template.Template
+	Version           string
+	Commit            string
+	Identifier        string
+	ApprovalThreshold float64
+	Storage           storage.Store
+	Outbox            *outbox.Dispatcher
+	Jobs              *jobs.Pool
+	GoogleOAuth       *oauth2.Config
+	ExpiryLeader      *leader.Elector
 }
@@ -0,0 +1,617 @@
+package config
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/livecarts"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// envPrefix starts the name of every environment variable the settings read
+const envPrefix = "SEATFLIP_"
+
+// sections of the settings that a command can bind flags for
+const (
+	SectionServer  = "server"
+	SectionDB      = "db"
+	SectionCarts   = "carts"
+	SectionRedis   = "redis"
+	SectionPusher  = "pusher"
+	SectionGoogle  = "google"
+	SectionTracing = "tracing"
+)
+
+// Secret is a setting that must never be logged. It prints as [redacted] however it is formatted.
+type Secret string
+
+// String redacts the secret
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// GoString redacts the secret
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// MarshalJSON redacts the secret
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(s.String())), nil
+}
+
+// LogValue redacts the secret
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// Value returns the secret itself
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// Settings is the application's configuration. Each setting is read from, in increasing
+// precedence: its default, the database.yml environment (database settings only), the config file
+// (YAML or TOML), SEATFLIP_* environment variables, and flags. A secret can be read from a file
+// instead, named by its *File setting, for Docker and Kubernetes secrets.
+type Settings struct {
+	Env         string `yaml:"env" toml:"env" env:"ENV" flag:"env" usage:"environment, selects the database.yml section"`
+	ConfigFile  string `yaml:"-" toml:"-" env:"CONFIG" flag:"config" usage:"YAML or TOML config file"`
+	DatabaseYML string `yaml:"database_yml" toml:"database_yml" env:"DATABASE_YML" flag:"dbConfig" usage:"database.yml file, ignored if missing"`
+
+	Server  ServerSettings  `yaml:"server" toml:"server" section:"server"`
+	DB      DBSettings      `yaml:"database" toml:"database" section:"db"`
+	Carts   CartSettings    `yaml:"carts" toml:"carts" section:"carts"`
+	Redis   RedisSettings   `yaml:"redis" toml:"redis" section:"redis"`
+	Pusher  PusherSettings  `yaml:"pusher" toml:"pusher" section:"pusher"`
+	Google  GoogleSettings  `yaml:"google" toml:"google" section:"google"`
+	Tracing TracingSettings `yaml:"tracing" toml:"tracing" section:"tracing"`
+}
+
+// ServerSettings configure the web server
+type ServerSettings struct {
+	Port              string        `yaml:"port" toml:"port" env:"PORT" flag:"port" usage:"port to listen on"`
+	UseCache          bool          `yaml:"cache" toml:"cache" env:"CACHE" flag:"cache" usage:"Use template cache"`
+	Identifier        string        `yaml:"identifier" toml:"identifier" env:"IDENTIFIER" flag:"identifier" usage:"unique identifier"`
+	Domain            string        `yaml:"domain" toml:"domain" env:"DOMAIN" flag:"domain" usage:"domain name (e.g. example.com)"`
+	InProduction      bool          `yaml:"production" toml:"production" env:"PRODUCTION" flag:"production" usage:"application is in production"`
+	ApprovalThreshold float64       `yaml:"approval_threshold" toml:"approval_threshold" env:"APPROVAL_THRESHOLD" flag:"approvalThreshold" usage:"cart total above which two buyers must approve (0 disables)"`
+	StoragePath       string        `yaml:"storage_path" toml:"storage_path" env:"STORAGE_PATH" flag:"storagePath" usage:"directory attachments are stored in"`
+	ShutdownDelay     time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay" env:"SHUTDOWN_DELAY" flag:"shutdownDelay" usage:"how long readiness fails before a shutdown stops taking connections"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdownTimeout" usage:"how long a graceful shutdown may take"`
+	LogLevel          slog.Level    `yaml:"log_level" toml:"log_level" env:"LOG_LEVEL" flag:"logLevel" usage:"lowest level logged: debug, info, warn or error"`
+	TrustedProxies    string        `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trustedProxies" usage:"comma separated addresses or CIDR ranges of proxies whose X-Forwarded-For is believed"`
+	MetricsToken      Secret        `yaml:"metrics_token" toml:"metrics_token" env:"METRICS_TOKEN" flag:"metricsToken" usage:"bearer token /metrics must be scraped with (unset turns /metrics off)"`
+	MetricsTokenFile  string        `yaml:"metrics_token_file" toml:"metrics_token_file" env:"METRICS_TOKEN_FILE" flag:"metricsTokenFile" usage:"file to read the metrics token from"`
+}
+
+// DBSettings say how to reach Postgres. URL, when set, is used instead of the other fields.
+type DBSettings struct {
+	Host         string `yaml:"host" toml:"host" env:"DB_HOST" flag:"dbhost" usage:"database host"`
+	Port         string `yaml:"port" toml:"port" env:"DB_PORT" flag:"dbport" usage:"database port"`
+	User         string `yaml:"user" toml:"user" env:"DB_USER" flag:"dbuser" usage:"database user"`
+	Password     Secret `yaml:"password" toml:"password" env:"DB_PASSWORD" flag:"dbpass" usage:"database password"`
+	PasswordFile string `yaml:"password_file" toml:"password_file" env:"DB_PASSWORD_FILE" flag:"dbpassFile" usage:"file to read the database password from"`
+	Name         string `yaml:"name" toml:"name" env:"DB_NAME" flag:"db" usage:"database name"`
+	SSLMode      string `yaml:"sslmode" toml:"sslmode" env:"DB_SSLMODE" flag:"dbssl" usage:"database ssl setting"`
+	URL          Secret `yaml:"url" toml:"url" env:"DATABASE_URL" flag:"dburl" usage:"database url, instead of the other database settings"`
+	URLFile      string `yaml:"url_file" toml:"url_file" env:"DATABASE_URL_FILE" flag:"dburlFile" usage:"file to read the database url from"`
+}
+
+// CartSettings choose where live carts are held
+type CartSettings struct {
+	Store string `yaml:"store" toml:"store" env:"CART_STORE" flag:"cartStore" usage:"where live carts are held: redis, or postgres to run without redis"`
+}
+
+// RedisSettings say how to reach Redis
+type RedisSettings struct {
+	Addr           string `yaml:"addr" toml:"addr" env:"REDIS_ADDR" flag:"redis" usage:"redis address"`
+	Password       Secret `yaml:"password" toml:"password" env:"REDIS_PASSWORD" flag:"redisPass" usage:"redis password"`
+	PasswordFile   string `yaml:"password_file" toml:"password_file" env:"REDIS_PASSWORD_FILE" flag:"redisPassFile" usage:"file to read the redis password from"`
+	DB             int    `yaml:"db" toml:"db" env:"REDIS_DB" flag:"redisDB" usage:"redis database number"`
+	KeyspaceEvents bool   `yaml:"configure_keyspace_events" toml:"configure_keyspace_events" env:"REDIS_KEYSPACE_EVENTS" flag:"redisKeyspaceEvents" usage:"turn on keyspace notifications with CONFIG SET (turn off for managed redis that forbids it)"`
+}
+
+// PusherSettings configure the realtime client
+type PusherSettings struct {
+	Host       string `yaml:"host" toml:"host" env:"PUSHER_HOST" flag:"pusherHost" usage:"pusher host"`
+	Port       string `yaml:"port" toml:"port" env:"PUSHER_PORT" flag:"pusherPort" usage:"pusher port"`
+	AppID      string `yaml:"app_id" toml:"app_id" env:"PUSHER_APP" flag:"pusherApp" usage:"pusher app id"`
+	Key        string `yaml:"key" toml:"key" env:"PUSHER_KEY" flag:"pusherKey" usage:"pusher key"`
+	Secret     Secret `yaml:"secret" toml:"secret" env:"PUSHER_SECRET" flag:"pusherSecret" usage:"pusher secret"`
+	SecretFile string `yaml:"secret_file" toml:"secret_file" env:"PUSHER_SECRET_FILE" flag:"pusherSecretFile" usage:"file to read the pusher secret from"`
+	Cluster    string `yaml:"cluster" toml:"cluster" env:"PUSHER_CLUSTER" flag:"pusherCluster" usage:"pusher cluster"`
+	Secure     bool   `yaml:"secure" toml:"secure" env:"PUSHER_SECURE" flag:"pusherSecure" usage:"pusher server uses SSL (true or false)"`
+}
+
+// GoogleSettings configure sign in with Google
+type GoogleSettings struct {
+	ClientID         string `yaml:"client_id" toml:"client_id" env:"GOOGLE_CLIENT_ID" flag:"googleClientID" usage:"google oauth client id"`
+	ClientSecret     Secret `yaml:"client_secret" toml:"client_secret" env:"GOOGLE_CLIENT_SECRET" flag:"googleClientSecret" usage:"google oauth client secret"`
+	ClientSecretFile string `yaml:"client_secret_file" toml:"client_secret_file" env:"GOOGLE_CLIENT_SECRET_FILE" flag:"googleClientSecretFile" usage:"file to read the google oauth client secret from"`
+	RedirectURL      string `yaml:"redirect_url" toml:"redirect_url" env:"GOOGLE_REDIRECT_URL" flag:"googleRedirectURL" usage:"google oauth redirect url"`
+}
+
+// TracingSettings say where trace spans are sent
+type TracingSettings struct {
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" flag:"tracing" usage:"where spans are sent: none, otlp or stdout"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint" env:"TRACING_ENDPOINT" flag:"tracingEndpoint" usage:"OTLP/HTTP collector host:port (default from OTEL_EXPORTER_OTLP_ENDPOINT, or localhost:4318)"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure" env:"TRACING_INSECURE" flag:"tracingInsecure" usage:"send spans to the collector without TLS"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" flag:"tracingSampleRatio" usage:"share of traces started here that are recorded, from 0 to 1"`
+}
+
+// DefaultSettings returns the settings used when nothing else sets them, which suit local development
+func DefaultSettings() Settings {
+	return Settings{
+		Env:         "development",
+		DatabaseYML: "database.yml",
+		Server: ServerSettings{
+			Port:            ":4000",
+			UseCache:        true,
+			Identifier:      "seatflip",
+			Domain:          "localhost",
+			StoragePath:     "./data/attachments",
+			ShutdownTimeout: 30 * time.Second,
+		},
+		DB: DBSettings{
+			Host:    "localhost",
+			Port:    "5432",
+			User:    "postgres",
+			Name:    "seatflip",
+			SSLMode: "disable",
+		},
+		Carts: CartSettings{
+			Store: livecarts.BackendRedis,
+		},
+		Redis: RedisSettings{
+			Addr:           ":6379",
+			KeyspaceEvents: true,
+		},
+		Pusher: PusherSettings{
+			Port:    "443",
+			Cluster: "mt1",
+			Secure:  true,
+		},
+		Google: GoogleSettings{
+			RedirectURL: "http://localhost:4000/auth/google/callback",
+		},
+		Tracing: TracingSettings{
+			Exporter:    tracing.ExporterNone,
+			SampleRatio: 1,
+		},
+	}
+}
+
+// DSN returns the postgres connection string
+func (d DBSettings) DSN() string {
+	if d.URL != "" {
+		return d.URL.Value()
+	}
+	// when developing locally, we often don't have a db password
+	if d.Password == "" {
+		return fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=%s timezone=UTC connect_timeout=5",
+			d.Host, d.Port, d.User, d.Name, d.SSLMode)
+	}
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s timezone=UTC connect_timeout=5",
+		d.Host, d.Port, d.User, d.Password.Value(), d.Name, d.SSLMode)
+}
+
+// setting is one field of the settings, with where it can be read from
+type setting struct {
+	value   reflect.Value
+	env     string
+	flag    string
+	usage   string
+	section string
+}
+
+// flagValue holds a flag's value until the other layers have been applied
+type flagValue struct {
+	s   *setting
+	raw string
+}
+
+func (f *flagValue) String() string {
+	if f == nil || f.s == nil || f.s.value.Type() == reflect.TypeOf(Secret("")) {
+		return ""
+	}
+	return fmt.Sprint(f.s.value.Interface())
+}
+
+func (f *flagValue) Set(x string) error {
+	// check it parses now, so a bad flag is reported like any other flag error
+	if err := setValue(reflect.New(f.s.value.Type()).Elem(), x); err != nil {
+		return err
+	}
+	f.raw = x
+	return nil
+}
+
+func (f *flagValue) IsBoolFlag() bool {
+	return f.s.value.Kind() == reflect.Bool
+}
+
+// Loader reads settings for a command
+type Loader struct {
+	fs       *flag.FlagSet
+	settings *Settings
+	fields   []*setting
+	flags    map[string]*flagValue
+	sections []string
+}
+
+// NewLoader registers flags on fs for the env and config file settings and for every setting in
+// sections, so each command only offers the flags it uses. All sections are still read from the
+// config file and the environment.
+func NewLoader(fs *flag.FlagSet, sections ...string) *Loader {
+	s := DefaultSettings()
+	l := &Loader{
+		fs:       fs,
+		settings: &s,
+		flags:    make(map[string]*flagValue),
+		sections: sections,
+	}
+	l.fields = collect(reflect.ValueOf(l.settings).Elem(), "")
+
+	for _, f := range l.fields {
+		if f.flag == "" || (f.section != "" && !l.has(f.section)) {
+			continue
+		}
+		v := &flagValue{s: f}
+		fs.Var(v, f.flag, f.usage)
+		l.flags[f.flag] = v
+	}
+	return l
+}
+
+// has reports whether the loader binds section
+func (l *Loader) has(section string) bool {
+	for _, s := range l.sections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
+
+// collect lists the settings in v, noting the section each belongs to
+func collect(v reflect.Value, section string) []*setting {
+	var fields []*setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			fields = append(fields, collect(v.Field(i), field.Tag.Get("section"))...)
+			continue
+		}
+		fields = append(fields, &setting{
+			value:   v.Field(i),
+			env:     field.Tag.Get("env"),
+			flag:    field.Tag.Get("flag"),
+			usage:   field.Tag.Get("usage"),
+			section: section,
+		})
+	}
+	return fields
+}
+
+// Load parses args into the flag set and returns the settings with every layer applied, checked
+// for mistakes
+func (l *Loader) Load(args []string) (*Settings, error) {
+	if err := l.fs.Parse(args); err != nil {
+		return nil, err
+	}
+	s := l.settings
+
+	// the env and config file settings decide which files are read, so they are settled first
+	l.applyEnv("ENV", "CONFIG", "DATABASE_YML")
+	l.applyFlags("env", "config", "dbConfig")
+
+	if err := s.readDatabaseYML(); err != nil {
+		return nil, err
+	}
+	if s.ConfigFile != "" {
+		if err := s.readFile(s.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	l.applyEnv()
+	l.applyFlags()
+
+	if err := s.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	if err := s.validate(l.sections); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// applyEnv sets settings from the environment; with names, only those settings
+func (l *Loader) applyEnv(names ...string) {
+	for _, f := range l.fields {
+		if f.env == "" || (len(names) > 0 && !contains(names, f.env)) {
+			continue
+		}
+		x, ok := os.LookupEnv(envPrefix + f.env)
+		if !ok {
+			continue
+		}
+		if err := setValue(f.value, x); err != nil {
+			slog.Warn("config: ignoring environment variable", "name", envPrefix+f.env, "err", err)
+		}
+	}
+}
+
+// applyFlags sets settings from the flags that were given; with names, only those flags
+func (l *Loader) applyFlags(names ...string) {
+	l.fs.Visit(func(fl *flag.Flag) {
+		v, ok := l.flags[fl.Name]
+		if !ok || (len(names) > 0 && !contains(names, fl.Name)) {
+			return
+		}
+		_ = setValue(v.s.value, v.raw)
+	})
+}
+
+func contains(list []string, x string) bool {
+	for _, y := range list {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
+
+// setValue parses x into v
+func setValue(v reflect.Value, x string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(x)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(x))
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(x)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(x)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(x)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// dbEnvironment is one environment of database.yml, as written for soda
+type dbEnvironment struct {
+	Database string `yaml:"database"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	URL      string `yaml:"url"`
+	Options  struct {
+		SSLMode string `yaml:"sslmode"`
+	} `yaml:"options"`
+}
+
+// readDatabaseYML applies the database settings of the current environment from database.yml,
+// which is a template that can read the environment with env and envOr
+func (s *Settings) readDatabaseYML() error {
+	if s.DatabaseYML == "" {
+		return nil
+	}
+	b, err := os.ReadFile(s.DatabaseYML)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(filepath.Base(s.DatabaseYML)).Funcs(template.FuncMap{
+		"env": os.Getenv,
+		"envOr": func(name, def string) string {
+			if x, ok := os.LookupEnv(name); ok {
+				return x
+			}
+			return def
+		},
+	}).Parse(string(b))
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", s.DatabaseYML, err)
+	}
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, nil); err != nil {
+		return fmt.Errorf("config: %s: %w", s.DatabaseYML, err)
+	}
+
+	var envs map[string]dbEnvironment
+	if err = yaml.Unmarshal(out.Bytes(), &envs); err != nil {
+		return fmt.Errorf("config: %s: %w", s.DatabaseYML, err)
+	}
+	e, ok := envs[s.Env]
+	if !ok {
+		return nil
+	}
+
+	if e.URL != "" {
+		s.DB.URL = Secret(e.URL)
+	}
+	setIf(&s.DB.Name, e.Database)
+	setIf(&s.DB.User, e.User)
+	setIf(&s.DB.Host, e.Host)
+	setIf(&s.DB.Port, e.Port)
+	setIf(&s.DB.SSLMode, e.Options.SSLMode)
+	if e.Password != "" {
+		s.DB.Password = Secret(e.Password)
+	}
+	return nil
+}
+
+func setIf(dst *string, x string) {
+	if x != "" {
+		*dst = x
+	}
+}
+
+// readFile applies a YAML or TOML config file, chosen by its extension. Settings the file leaves
+// out keep their values.
+func (s *Settings) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, s)
+	case ".toml":
+		err = toml.Unmarshal(b, s)
+	default:
+		return fmt.Errorf("config: %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// readSecretFiles reads secrets that were given as files, which win over the secret itself
+func (s *Settings) readSecretFiles() error {
+	files := []struct {
+		path   string
+		secret *Secret
+	}{
+		{s.DB.PasswordFile, &s.DB.Password},
+		{s.DB.URLFile, &s.DB.URL},
+		{s.Redis.PasswordFile, &s.Redis.Password},
+		{s.Pusher.SecretFile, &s.Pusher.Secret},
+		{s.Google.ClientSecretFile, &s.Google.ClientSecret},
+		{s.Server.MetricsTokenFile, &s.Server.MetricsToken},
+	}
+
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		b, err := os.ReadFile(f.path)
+		if err != nil {
+			return fmt.Errorf("config: reading secret: %w", err)
+		}
+		*f.secret = Secret(strings.TrimRight(string(b), "\r\n"))
+	}
+	return nil
+}
+
+// validate checks the settings of the given sections. Settings that only matter in production are
+// warned about in development.
+func (s *Settings) validate(sections []string) error {
+	var problems []string
+	missing := func(section, name, value string, required bool) {
+		if value != "" || !contains(sections, section) {
+			return
+		}
+		if required {
+			problems = append(problems, name+" is required")
+		} else {
+			slog.Warn("config: setting is not set", "setting", name)
+		}
+	}
+
+	missing(SectionServer, "port", s.Server.Port, true)
+	missing(SectionServer, "identifier", s.Server.Identifier, true)
+	missing(SectionServer, "metrics token", s.Server.MetricsToken.Value(), false)
+	if contains(sections, SectionServer) && s.Server.ApprovalThreshold < 0 {
+		problems = append(problems, "approval threshold can't be negative")
+	}
+	if contains(sections, SectionServer) && s.Server.ShutdownTimeout <= 0 {
+		problems = append(problems, "shutdown timeout must be positive")
+	}
+	if contains(sections, SectionServer) && s.Server.ShutdownDelay < 0 {
+		problems = append(problems, "shutdown delay can't be negative")
+	}
+	if _, err := audit.ParseProxies(s.Server.TrustedProxies); contains(sections, SectionServer) && err != nil {
+		problems = append(problems, "trusted proxies must be addresses or CIDR ranges")
+	}
+
+	if s.DB.URL == "" {
+		missing(SectionDB, "database host", s.DB.Host, true)
+		missing(SectionDB, "database port", s.DB.Port, true)
+		missing(SectionDB, "database user", s.DB.User, true)
+		missing(SectionDB, "database name", s.DB.Name, true)
+	}
+
+	if contains(sections, SectionCarts) {
+		switch s.Carts.Store {
+		case livecarts.BackendRedis:
+			missing(SectionRedis, "redis address", s.Redis.Addr, true)
+		case livecarts.BackendPostgres:
+		default:
+			problems = append(problems, "cart store must be redis or postgres")
+		}
+	}
+
+	if contains(sections, SectionTracing) {
+		switch s.Tracing.Exporter {
+		case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+		default:
+			problems = append(problems, "tracing exporter must be none, otlp or stdout")
+		}
+		if s.Tracing.SampleRatio < 0 || s.Tracing.SampleRatio > 1 {
+			problems = append(problems, "tracing sample ratio must be between 0 and 1")
+		}
+	}
+
+	production := s.Server.InProduction
+	missing(SectionPusher, "pusher app id", s.Pusher.AppID, production)
+	missing(SectionPusher, "pusher key", s.Pusher.Key, production)
+	missing(SectionPusher, "pusher secret", s.Pusher.Secret.Value(), production)
+	missing(SectionGoogle, "google client id", s.Google.ClientID, production)
+	missing(SectionGoogle, "google client secret", s.Google.ClientSecret.Value(), production)
+	missing(SectionGoogle, "google redirect url", s.Google.RedirectURL, true)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("config: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
@@ -1,12 +1,14 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	_ "github.com/jackc/pgconn" // need this and next two for pgx
-	_ "github.com/jackc/pgx/v4"
-	_ "github.com/jackc/pgx/v4/stdlib"
-	"log"
+	"github.com/SeatSnobAri/seatflipsite/internal/metrics"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	_ "github.com/jackc/pgconn" // need this for pgx
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"log/slog"
 	"time"
 )
 
@@ -23,10 +25,15 @@ const maxDbLifetime = 5 * time.Minute
 
 // ConnectPostgres creates database pool for postgres
 func ConnectPostgres(dsn string) (*DB, error) {
-	d, err := sql.Open("pgx", dsn)
+	config, err := pgx.ParseConfig(dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	// time every query for the metrics, and trace it when it's part of a request
+	config.Logger = loggers{metrics.PgxLogger{}, tracing.PgxLogger{}}
+	config.LogLevel = pgx.LogLevelInfo
+
+	d := stdlib.OpenDB(*config)
 
 	d.SetMaxOpenConns(maxOpenDbConn)
 	d.SetMaxIdleConns(maxIdleDbConn)
@@ -38,13 +45,22 @@ func ConnectPostgres(dsn string) (*DB, error) {
 	return dbConn, err
 }
 
+// loggers hands each of pgx's log entries to every logger
+type loggers []pgx.Logger
+
+func (l loggers) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	for _, logger := range l {
+		logger.Log(ctx, level, msg, data)
+	}
+}
+
 // testDB pings database
 func testDB(err error, d *sql.DB) error {
 	err = d.Ping()
 	if err != nil {
-		fmt.Println("Error!", err)
+		slog.Error("can't ping database", "err", err)
 	} else {
-		log.Println("*** Pinged database successfully! ***")
+		slog.Info("pinged database")
 	}
 	return err
 }
@@ -0,0 +1,242 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// rows written between flushes to the underlying writer
+const flushEvery = 100
+
+// ErrUnknownFormat is returned for formats other than csv and ndjson
+var ErrUnknownFormat = errors.New("export: format must be csv or ndjson")
+
+var cartColumns = []string{
+	"id", "created_at", "event_date", "event_name", "event_venue", "seat_info", "ticket_info",
+	"ticket_price", "ticket_total", "quantity", "bought", "stock_type", "user_id",
+}
+
+var inventoryColumns = []string{
+	"id", "cart_id", "created_at", "updated_at", "event_date", "event_name", "event_venue", "seat_info",
+	"state", "quantity", "cost_basis", "fees", "listing_price", "sale_price", "user_id",
+}
+
+var auditColumns = []string{
+	"id", "created_at", "actor", "action", "target_type", "target_id", "before", "after", "ip", "user_agent",
+	"method", "path",
+}
+
+// ContentType returns the http content type for format
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// ValidFormat reports whether format can be exported
+func ValidFormat(format string) bool {
+	return format == FormatCSV || format == FormatNDJSON
+}
+
+// Carts writes every cart matching f to w in format, streaming from the database
+func Carts(ctx context.Context, db repository.DatabaseRepo, w io.Writer, format string, f models.ExportFilter) error {
+	enc, err := newEncoder(w, format, cartColumns)
+	if err != nil {
+		return err
+	}
+
+	err = db.ExportCarts(ctx, f, func(c models.Cart) error {
+		return enc.encode(c, []string{
+			c.ID,
+			c.CreatedAt.Format(time.RFC3339),
+			c.EventDate,
+			c.EventName,
+			c.EventVenue,
+			c.SeatInfo,
+			c.TicketInfo,
+			c.TicketPrice,
+			money(c.TicketTotal),
+			strconv.Itoa(c.Quantity),
+			strconv.FormatBool(c.Bought),
+			c.StockType,
+			c.UserID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return enc.flush()
+}
+
+// Purchases writes every inventory item matching f to w in format, streaming from the database
+func Purchases(ctx context.Context, db repository.DatabaseRepo, w io.Writer, format string, f models.ExportFilter) error {
+	enc, err := newEncoder(w, format, inventoryColumns)
+	if err != nil {
+		return err
+	}
+
+	err = db.ExportInventory(ctx, f, func(i models.InventoryItem) error {
+		return enc.encode(i, []string{
+			strconv.Itoa(i.ID),
+			i.CartID,
+			i.CreatedAt.Format(time.RFC3339),
+			i.UpdatedAt.Format(time.RFC3339),
+			i.EventDate,
+			i.EventName,
+			i.EventVenue,
+			i.SeatInfo,
+			i.State,
+			strconv.Itoa(i.Quantity),
+			money(i.CostBasis),
+			money(i.Fees),
+			money(i.ListingPrice),
+			money(i.SalePrice),
+			i.UserID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return enc.flush()
+}
+
+// AuditEvents writes every audit event matching f to w in format, oldest first
+func AuditEvents(ctx context.Context, db repository.DatabaseRepo, w io.Writer, format string, f models.AuditFilter) error {
+	enc, err := newEncoder(w, format, auditColumns)
+	if err != nil {
+		return err
+	}
+
+	err = db.ExportAuditEvents(ctx, f, func(e models.AuditEvent) error {
+		return enc.encode(e, []string{
+			strconv.FormatInt(e.ID, 10),
+			e.CreatedAt.Format(time.RFC3339),
+			e.Actor,
+			e.Action,
+			e.TargetType,
+			e.TargetID,
+			string(e.Before),
+			string(e.After),
+			e.IP,
+			e.UserAgent,
+			e.Method,
+			e.Path,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return enc.flush()
+}
+
+func money(f float64) string {
+	return fmt.Sprintf("%.2f", f)
+}
+
+// Stream readies w for an export of name in format and returns the writer to export into. It lifts
+// the server's write timeout, since an export can take longer, and sets the download headers.
+// Flushes of the returned writer reach the client through any middleware wrapping w.
+func Stream(w http.ResponseWriter, name, format string) (io.Writer, error) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("export: can't lift the write deadline: %w", err)
+	}
+
+	w.Header().Set("Content-Type", ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`,
+		name, time.Now().Format("20060102-150405"), format))
+
+	return &responseWriter{Writer: w, rc: rc}, nil
+}
+
+// responseWriter flushes with a ResponseController, which finds the connection's Flusher under
+// wrappers, such as the session's, that don't implement http.Flusher themselves
+type responseWriter struct {
+	io.Writer
+	rc *http.ResponseController
+}
+
+func (w *responseWriter) Flush() error {
+	return w.rc.Flush()
+}
+
+// flusher is a writer that can push what it has buffered through to the client
+type flusher interface {
+	Flush() error
+}
+
+// encoder writes one row at a time as either a csv record or a json line
+type encoder struct {
+	w     io.Writer
+	csv   *csv.Writer
+	json  *json.Encoder
+	count int
+}
+
+func newEncoder(w io.Writer, format string, columns []string) (*encoder, error) {
+	enc := &encoder{w: w}
+
+	switch format {
+	case FormatCSV:
+		enc.csv = csv.NewWriter(w)
+		if err := enc.csv.Write(columns); err != nil {
+			return nil, err
+		}
+	case FormatNDJSON:
+		enc.json = json.NewEncoder(w)
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	return enc, nil
+}
+
+func (e *encoder) encode(v any, record []string) error {
+	var err error
+	if e.csv != nil {
+		err = e.csv.Write(record)
+	} else {
+		err = e.json.Encode(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.count++
+	if e.count%flushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush pushes buffered rows through to the client
+func (e *encoder) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if f, ok := e.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
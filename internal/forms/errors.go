@@ -1,5 +1,7 @@
 package forms
 
+import "sort"
+
 type errors map[string][]string
 
 // Add adds an error message for a given form field
@@ -15,3 +17,18 @@ func (e errors) Get(field string) string {
 	}
 	return es[0]
 }
+
+// First returns the first error message across all fields, ordered by field name
+func (e errors) First() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if msg := e.Get(field); msg != "" {
+			return field + ": " + msg
+		}
+	}
+	return ""
+}
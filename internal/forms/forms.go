@@ -3,7 +3,9 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -62,3 +64,59 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsFloat checks that fields, when present, hold a non-negative number
+func (f *Form) IsFloat(fields ...string) {
+	for _, field := range fields {
+		x := f.Get(field)
+		if x == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(x, 64)
+		if err != nil || v < 0 {
+			f.Errors.Add(field, "This field must be a positive number")
+		}
+	}
+}
+
+// IsInt checks that fields, when present, hold a whole number of at least one
+func (f *Form) IsInt(fields ...string) {
+	for _, field := range fields {
+		x := f.Get(field)
+		if x == "" {
+			continue
+		}
+		v, err := strconv.Atoi(x)
+		if err != nil || v < 1 {
+			f.Errors.Add(field, "This field must be a whole number")
+		}
+	}
+}
+
+// IsDate checks that field, when present, parses with one of layouts
+func (f *Form) IsDate(field string, layouts ...string) {
+	x := f.Get(field)
+	if x == "" {
+		return
+	}
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, x); err == nil {
+			return
+		}
+	}
+	f.Errors.Add(field, "Invalid date")
+}
+
+// IsIn checks that field, when present, is one of values
+func (f *Form) IsIn(field string, values ...string) {
+	x := f.Get(field)
+	if x == "" {
+		return
+	}
+	for _, v := range values {
+		if x == v {
+			return
+		}
+	}
+	f.Errors.Add(field, fmt.Sprintf("Must be one of %s", strings.Join(values, ", ")))
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/storage"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+// saveAttachment stores the file read from r and links it to the cart or inventory item set on a
+func (repo *DBRepo) saveAttachment(ctx context.Context, r io.Reader, a models.Attachment) (models.Attachment, error) {
+	o, err := storage.Save(ctx, repo.App.Storage, r)
+	if err != nil {
+		return a, err
+	}
+
+	a.SHA256 = o.Key
+	a.Size = o.Size
+	a.ContentType = o.ContentType
+	a.Filename = filepath.Base(a.Filename)
+	if a.Filename == "." || a.Filename == string(filepath.Separator) {
+		a.Filename = o.Key
+	}
+
+	a.ID, err = repo.DB.InsertAttachment(a)
+	return a, err
+}
+
+// PostAttachment uploads a receipt, screenshot or ticket PDF for an inventory item or a cart
+func (repo *DBRepo) PostAttachment(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, storage.MaxSize+1<<20)
+	err := r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		repo.App.Session.Put(r.Context(), "error", fmt.Sprintf("Files can't be larger than %d MB", storage.MaxSize>>20))
+		http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+		return
+	}
+
+	a := models.Attachment{
+		CartID: r.Form.Get("cart_id"),
+		UserID: repo.App.Session.GetString(r.Context(), "user_id"),
+	}
+	a.InventoryID, _ = strconv.Atoi(r.Form.Get("inventory_id"))
+
+	if a.InventoryID != 0 {
+		if _, err = repo.DB.GetInventoryById(a.InventoryID); err != nil {
+			a.InventoryID = 0
+		}
+	}
+	if a.CartID != "" {
+		if _, err = repo.DB.GetCartById(a.CartID); err != nil {
+			a.CartID = ""
+		}
+	}
+	if a.InventoryID == 0 && a.CartID == "" {
+		repo.App.Session.Put(r.Context(), "error", "Attachments need a cart or an inventory item")
+		http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		repo.App.Session.Put(r.Context(), "error", "Choose a file to upload")
+		http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	a.Filename = header.Filename
+	a, err = repo.saveAttachment(r.Context(), file, a)
+	if errors.Is(err, storage.ErrTooLarge) || errors.Is(err, storage.ErrUnsupportedType) {
+		repo.App.Session.Put(r.Context(), "error", "Attachments must be a screenshot or PDF under 10 MB")
+		http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.AttachmentAdd).Target("attachment", strconv.Itoa(a.ID)).Change(nil, a).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Uploaded %s", a.Filename))
+	http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+}
+
+// Attachment downloads a stored file. Files are only served to signed in users, never through the
+// public static file server.
+func (repo *DBRepo) Attachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	a, err := repo.DB.GetAttachmentById(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := repo.App.Storage.Get(r.Context(), a.SHA256)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", a.Filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400, immutable")
+
+	_, err = io.Copy(w, f)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't send attachment", "err", err)
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/export"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"net/http"
+	"time"
+)
+
+// recentAuditEvents is how many events the audit page shows
+const recentAuditEvents = 500
+
+// readAuditFilter builds an audit filter from the query string
+func readAuditFilter(r *http.Request) (models.AuditFilter, error) {
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		Actor:      q.Get("actor"),
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+	}
+
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(reportDateLayout, from)
+		if err != nil {
+			return f, errors.New("from must be a date in YYYY-MM-DD format")
+		}
+		f.From = t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse(reportDateLayout, to)
+		if err != nil {
+			return f, errors.New("to must be a date in YYYY-MM-DD format")
+		}
+		f.To = t.AddDate(0, 0, 1)
+	}
+
+	return f, nil
+}
+
+// AdminAudit displays the audit log, filtered by the query string
+func (repo *DBRepo) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	f, err := readAuditFilter(r)
+	if err != nil {
+		repo.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/admin/audit", http.StatusSeeOther)
+		return
+	}
+
+	events, err := repo.DB.AuditEvents(f, recentAuditEvents)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	for _, k := range []string{"from", "to", "actor", "action", "target_type", "target_id"} {
+		stringMap[k] = r.URL.Query().Get(k)
+	}
+
+	data := make(map[string]interface{})
+	data["events"] = events
+	data["actions"] = audit.Actions
+
+	render.Template(w, r, "audit.page.gohtml", &templates.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// ExportAudit streams the audit log as csv or ndjson
+func (repo *DBRepo) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if !export.ValidFormat(format) {
+		helpers.ErrorJSON(w, export.ErrUnknownFormat)
+		return
+	}
+
+	f, err := readAuditFilter(r)
+	if err != nil {
+		helpers.ErrorJSON(w, err)
+		return
+	}
+
+	out, err := export.Stream(w, "audit", format)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.AuditExport).Change(nil, map[string]any{"format": format, "filter": f}).Record(repo.DB)
+
+	err = export.AuditEvents(r.Context(), repo.DB, out, format, f)
+	if err != nil {
+		// headers are already sent, so all we can do is log and cut the response short
+		logging.FromContext(r.Context()).Error("export failed", "err", err)
+	}
+}
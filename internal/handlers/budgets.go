@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/forms"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"strconv"
+)
+
+// AdminBudgets displays budgets and the buys waiting for an override
+func (repo *DBRepo) AdminBudgets(w http.ResponseWriter, r *http.Request) {
+	repo.renderBudgets(w, r, forms.New(nil))
+}
+
+func (repo *DBRepo) renderBudgets(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	budgets, err := repo.DB.AllBudgets()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	overrides, err := repo.DB.PendingOverrides()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["budgets"] = budgets
+	data["overrides"] = overrides
+	data["scopes"] = models.BudgetScopes
+	data["periods"] = models.BudgetPeriods
+
+	render.Template(w, r, "budgets.page.gohtml", &templates.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostBudget creates or updates a budget
+func (repo *DBRepo) PostBudget(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("scope", "scope_key", "amount", "period")
+	form.IsIn("scope", models.BudgetScopes...)
+	form.IsIn("period", models.BudgetPeriods...)
+	form.IsFloat("amount")
+
+	if !form.Valid() {
+		repo.renderBudgets(w, r, form)
+		return
+	}
+
+	b := models.Budget{
+		Scope:  form.Get("scope"),
+		Key:    form.Get("scope_key"),
+		Period: form.Get("period"),
+	}
+	b.Amount, _ = strconv.ParseFloat(form.Get("amount"), 64)
+
+	err = repo.DB.SaveBudget(b)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.BudgetSave).Target("budget", b.Scope+":"+b.Key+":"+b.Period).Change(nil, b).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", "Budget saved")
+	http.Redirect(w, r, "/admin/budgets", http.StatusSeeOther)
+}
+
+// DeleteBudget removes a budget
+func (repo *DBRepo) DeleteBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = repo.DB.DeleteBudget(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.BudgetDelete).Target("budget", strconv.Itoa(id)).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", "Budget deleted")
+	http.Redirect(w, r, "/admin/budgets", http.StatusSeeOther)
+}
+
+// ApproveOverride buys a cart that a budget refused
+func (repo *DBRepo) ApproveOverride(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	msg, err := repo.liveCart(id)
+	if err != nil {
+		_ = repo.DB.RequestOverride(id, false)
+		repo.App.Session.Put(r.Context(), "error", "That cart has expired")
+		http.Redirect(w, r, "/admin/budgets", http.StatusSeeOther)
+		return
+	}
+
+	adminID := repo.App.Session.GetString(r.Context(), "user_id")
+	err = repo.DB.BuyCart(id, adminID, true, 0)
+	if err != nil {
+		repo.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/admin/budgets", http.StatusSeeOther)
+		return
+	}
+
+	repo.completeBuy(msg, adminID, true)
+
+	repo.auditEvent(r, audit.Override).Target("cart", id).
+		Change(msg, map[string]string{"status": "bought"}).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Bought %s", msg.EventName))
+	http.Redirect(w, r, "/admin/budgets", http.StatusSeeOther)
+}
+
+// DeclineOverride leaves a refused cart unbought
+func (repo *DBRepo) DeclineOverride(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := repo.DB.RequestOverride(id, false)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.Override).Target("cart", id).
+		Change(nil, map[string]string{"status": "declined"}).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", "Override declined")
+	http.Redirect(w, r, "/admin/budgets", http.StatusSeeOther)
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/history"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/storage"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxBrokerBytes leaves room for a base64 attachment on top of the rest of the payload
+	maxBrokerBytes = storage.MaxSize*4/3 + 1<<20
+	// discrepancyTolerance is how far the charged total can drift from the approved total, in
+	// dollars, before the confirmation is flagged
+	discrepancyTolerance = 0.01
+	// recentConfirmations is how many confirmations the confirmations page shows
+	recentConfirmations = 200
+)
+
+// Confirm is the broker action the agent sends once checkout has finished. The charged total is
+// reconciled against the total the buyers approved and any difference is flagged to the buyers.
+func (repo *DBRepo) Confirm(w http.ResponseWriter, r *http.Request, p models.ConfirmPayload, userID string) {
+	cart, err := repo.DB.GetCartById(p.UUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ErrorJSON(w, errors.New("unknown cart"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if cart.UserID != userID {
+		helpers.ErrorJSON(w, errors.New("only the agent who found the cart can confirm it"), http.StatusForbidden)
+		return
+	}
+
+	c, err := reconcile(cart, p)
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if p.Attachment != "" {
+		b, err := decodeAttachment(p.Attachment)
+		if err != nil {
+			helpers.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		name := p.AttachmentName
+		if name == "" {
+			name = "confirmation-" + cart.ID
+		}
+		a, err := repo.saveAttachment(repo.baseContext(), bytes.NewReader(b), models.Attachment{
+			CartID:   cart.ID,
+			UserID:   userID,
+			Filename: name,
+		})
+		if errors.Is(err, storage.ErrTooLarge) || errors.Is(err, storage.ErrUnsupportedType) {
+			helpers.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			helpers.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		c.AttachmentID = a.ID
+	}
+
+	c.ID, err = repo.DB.ConfirmCart(c)
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	audit.New(r, userID, audit.Confirm).Target("cart", cart.ID).Change(cart, c).Record(repo.DB)
+
+	repo.cartEvent(cart.ID, models.CartConfirmed, userID, history.Confirmed{
+		OrderNumber:  c.OrderNumber,
+		ChargedTotal: c.ChargedTotal,
+		Flagged:      c.Flagged,
+	})
+	repo.broadcastConfirmation(c)
+
+	var resp models.JsonResponse
+	resp.Error = false
+	resp.Message = "order confirmed"
+	if c.Flagged {
+		resp.Message = fmt.Sprintf("order confirmed, but %s was charged against %s approved",
+			render.Money(c.ChargedTotal), render.Money(c.ApprovedTotal))
+	}
+	resp.Data = c
+
+	helpers.WriteJSON(w, http.StatusAccepted, &resp)
+}
+
+// reconcile checks a confirmation against the bought cart
+func reconcile(cart models.Cart, p models.ConfirmPayload) (models.Confirmation, error) {
+	c := models.Confirmation{
+		CartID:        cart.ID,
+		UserID:        cart.UserID,
+		EventName:     cart.EventName,
+		OrderNumber:   strings.TrimSpace(p.OrderNumber),
+		ApprovedTotal: cart.TicketTotal,
+	}
+
+	if !cart.Bought {
+		return c, models.ErrNotBought
+	}
+	if c.OrderNumber == "" {
+		return c, errors.New("an order number is required")
+	}
+
+	var err error
+	c.ChargedTotal, c.Fees, err = p.Amounts()
+	if err != nil {
+		return c, err
+	}
+
+	c.Discrepancy = math.Round((c.ChargedTotal-c.ApprovedTotal)*100) / 100
+	c.Flagged = math.Abs(c.Discrepancy) > discrepancyTolerance
+
+	return c, nil
+}
+
+// decodeAttachment decodes a base64 screenshot or PDF, which may be sent as a data URL. The store
+// checks what the bytes actually are.
+func decodeAttachment(s string) ([]byte, error) {
+	if i := strings.Index(s, ","); strings.HasPrefix(s, "data:") && i > 0 {
+		s = s[i+1:]
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("attachment is not valid base64")
+	}
+	return b, nil
+}
+
+// broadcastConfirmation tells the dashboards a cart has been checked out, warning the buyers when
+// the charged total doesn't match what they approved
+func (repo *DBRepo) broadcastConfirmation(c models.Confirmation) {
+	data := make(map[string]string)
+	data["uuid"] = c.CartID
+	data["event_name"] = c.EventName
+	data["order_number"] = c.OrderNumber
+	data["charged_total"] = render.Money(c.ChargedTotal)
+	data["approved_total"] = render.Money(c.ApprovedTotal)
+	data["discrepancy"] = render.Money(c.Discrepancy)
+	data["flagged"] = strconv.FormatBool(c.Flagged)
+
+	repo.broadcastMessage("public-channel", "confirmed-row", data)
+
+	if c.Flagged {
+		slog.Warn("charged total doesn't match the approved total", "cart_id", c.CartID,
+			"order_number", c.OrderNumber, "charged", c.ChargedTotal, "approved", c.ApprovedTotal)
+	}
+}
+
+// AdminConfirmations displays recent checkout confirmations
+func (repo *DBRepo) AdminConfirmations(w http.ResponseWriter, r *http.Request) {
+	flagged := r.URL.Query().Get("flagged") == "true"
+
+	confirmations, err := repo.DB.AllConfirmations(recentConfirmations, flagged)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["confirmations"] = confirmations
+	data["flagged"] = flagged
+
+	render.Template(w, r, "confirmations.page.gohtml", &templates.TemplateData{
+		Data: data,
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/metrics"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+	"time"
+)
+
+const (
+	// sweepInterval is how often open carts are checked against the live carts, for expiries that
+	// were missed
+	sweepInterval = time.Minute
+	// sweepWindow is how far back the sweep looks for carts that never expired
+	sweepWindow = 24 * time.Hour
+)
+
+// WatchExpiries records and broadcasts carts whose holds run out, until ctx is done. Every time the
+// live cart store starts watching, and every sweepInterval, it sweeps for expiries it didn't hear
+// about.
+func (repo *DBRepo) WatchExpiries(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				repo.SweepExpired(ctx)
+			}
+		}
+	}()
+
+	repo.App.Carts.Watch(ctx, repo.SweepExpired, repo.expireCart)
+}
+
+// SweepExpired expires the carts that are open in Postgres but no longer live, catching expiries
+// that happened while nobody was watching or when redis keyspace events are off
+func (repo *DBRepo) SweepExpired(ctx context.Context) {
+	ids, err := repo.DB.UnexpiredCarts(time.Now().Add(-sweepWindow))
+	if err != nil {
+		slog.Error("expiry sweep: can't get open carts", "err", err)
+		return
+	}
+
+	for _, id := range ids {
+		live, err := repo.App.Carts.Exists(ctx, id)
+		if err != nil {
+			slog.Error("expiry sweep: can't check live cart", "cart_id", id, "err", err)
+			return
+		}
+		if !live {
+			slog.Info("expiry sweep: cart expired without an event", "cart_id", id)
+			repo.expireCart(id)
+		}
+	}
+}
+
+// expireCart records that a cart's hold ran out and takes it off the dashboards. A cart is only
+// expired once, however many times its expiry is seen.
+func (repo *DBRepo) expireCart(id string) {
+	// nothing traced asks for an expiry, so each is a trace of its own
+	ctx, span := tracing.Start(context.Background(), "cart expiry", attribute.String("cart.id", id))
+	defer span.End()
+	repo = repo.withContext(ctx)
+
+	ok, err := repo.DB.InsertCartExpiry(id)
+	if err != nil {
+		slog.Error("can't record cart expiry", "cart_id", id, "err", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	repo.recordCart(metrics.CartExpired, id)
+
+	data := make(map[string]string)
+	data["del"] = id
+
+	err = repo.trigger("public-channel", "expired-row", data)
+	if err != nil {
+		slog.Error("can't broadcast cart expiry", "cart_id", id, "err", err)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/export"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// readExportFilter builds an export filter from a query string or form
+func readExportFilter(q url.Values) (models.ExportFilter, error) {
+	var f models.ExportFilter
+
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(reportDateLayout, from)
+		if err != nil {
+			return f, errors.New("from must be a date in YYYY-MM-DD format")
+		}
+		f.From = t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse(reportDateLayout, to)
+		if err != nil {
+			return f, errors.New("to must be a date in YYYY-MM-DD format")
+		}
+		f.To = t.AddDate(0, 0, 1)
+	}
+	f.State = q.Get("state")
+	f.Event = q.Get("event")
+	f.Agent = q.Get("agent")
+	f.Team = q.Get("team")
+
+	return f, nil
+}
+
+// ExportCarts streams carts as csv or ndjson
+func (repo *DBRepo) ExportCarts(w http.ResponseWriter, r *http.Request) {
+	repo.streamExport(w, r, "carts", export.Carts)
+}
+
+// ExportPurchases streams purchased inventory as csv or ndjson
+func (repo *DBRepo) ExportPurchases(w http.ResponseWriter, r *http.Request) {
+	repo.streamExport(w, r, "purchases", export.Purchases)
+}
+
+type exportFunc func(ctx context.Context, db repository.DatabaseRepo, w io.Writer, format string, f models.ExportFilter) error
+
+// streamExport validates the request and streams the export to the client
+func (repo *DBRepo) streamExport(w http.ResponseWriter, r *http.Request, name string, fn exportFunc) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if !export.ValidFormat(format) {
+		helpers.ErrorJSON(w, export.ErrUnknownFormat)
+		return
+	}
+
+	f, err := readExportFilter(r.URL.Query())
+	if err != nil {
+		helpers.ErrorJSON(w, err)
+		return
+	}
+
+	out, err := export.Stream(w, name, format)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.Export).Target("export", name).
+		Change(nil, map[string]any{"format": format, "filter": f}).Record(repo.DB)
+
+	err = fn(r.Context(), repo.DB, out, format, f)
+	if err != nil {
+		// headers are already sent, so all we can do is log and cut the response short
+		logging.FromContext(r.Context()).Error("export failed", "err", err)
+	}
+}
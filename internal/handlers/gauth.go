@@ -6,25 +6,18 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
 	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
 	"github.com/SeatSnobAri/seatflipsite/internal/models"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 )
 
-var googleOauthConfig = &oauth2.Config{
-	//RedirectURL:  "https://seatflip.ninja/auth/google/callback",
-	RedirectURL:  "http://localhost:4000/auth/google/callback",
-	ClientID:     "46211357222-2tgfbaigpul4vn2hv1hp0v9v3n3sp8a4.apps.googleusercontent.com",
-	ClientSecret: "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",
-	Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email"},
-	Endpoint:     google.Endpoint,
-}
-
 const oauthGoogleUrlAPI = "https://www.googleapis.com/oauth2/v2/userinfo?access_token="
 
 func (repo *DBRepo) OauthGoogleLogin(w http.ResponseWriter, r *http.Request) {
@@ -41,7 +34,7 @@ func (repo *DBRepo) OauthGoogleLogin(w http.ResponseWriter, r *http.Request) {
 		AuthCodeURL receive state that is a token to protect the user from CSRF attacks. You must always provide a non-empty string and
 		validate that it matches the state query parameter on your redirect callback.
 	*/
-	u := googleOauthConfig.AuthCodeURL(oauthState)
+	u := repo.App.GoogleOAuth.AuthCodeURL(oauthState)
 	http.Redirect(w, r, u, http.StatusTemporaryRedirect)
 }
 
@@ -49,13 +42,13 @@ func (repo *DBRepo) OauthGoogleCallback(w http.ResponseWriter, r *http.Request)
 	// Read oauthState from Cookie
 	oauthState, _ := r.Cookie("oauthstate")
 	if r.FormValue("state") != oauthState.Value {
-		log.Println("invalid oauth google state")
+		logging.FromContext(r.Context()).Warn("invalid oauth google state")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 	data, err := repo.getUserDataFromGoogle(r.FormValue("code"))
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Error("can't get user from google", "err", err)
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
@@ -63,10 +56,12 @@ func (repo *DBRepo) OauthGoogleCallback(w http.ResponseWriter, r *http.Request)
 
 	err = json.Unmarshal(data, &response)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Error("can't decode google user", "err", err)
 		return
 	}
 	if response.Verified_email == false {
+		audit.New(r, response.Id, audit.LoginFailed).Target("user", response.Id).
+			Change(nil, map[string]string{"reason": "unverified email"}).Record(repo.DB)
 		repo.App.Session.Put(r.Context(), "error", "User must verify google account")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
@@ -74,11 +69,25 @@ func (repo *DBRepo) OauthGoogleCallback(w http.ResponseWriter, r *http.Request)
 
 	user, err := repo.DB.GetUserById(response.Id)
 	if err != nil {
+		audit.New(r, response.Id, audit.LoginFailed).Target("user", response.Id).
+			Change(nil, map[string]string{"reason": "no account"}).Record(repo.DB)
 		repo.App.Session.Put(r.Context(), "error", "User doesn't exist")
 		repo.App.Session.Put(r.Context(), "user", response)
 		http.Redirect(w, r, "/user/sign-up", http.StatusSeeOther)
 		return
 	}
+	if !user.Active() {
+		audit.New(r, user.ID, audit.LoginFailed).Target("user", user.ID).
+			Change(nil, map[string]string{"reason": "account " + user.Status}).Record(repo.DB)
+		if user.Status == models.UserPending {
+			repo.App.Session.Put(r.Context(), "error", "Your account is waiting for an admin to approve it")
+		} else {
+			repo.App.Session.Put(r.Context(), "error", "Your account has been disabled")
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	audit.New(r, user.ID, audit.Login).Target("user", user.ID).Record(repo.DB)
 	app.Session.Put(r.Context(), "user_id", user.ID)
 	app.Session.Put(r.Context(), "user", user)
 	repo.App.Session.Put(r.Context(), "flash", "Logged in successfully")
@@ -100,7 +109,7 @@ func (repo *DBRepo) generateStateOauthCookie(w http.ResponseWriter) string {
 func (repo *DBRepo) getUserDataFromGoogle(code string) ([]byte, error) {
 	// Use code to get token and get user info from Google.
 
-	token, err := googleOauthConfig.Exchange(context.Background(), code)
+	token, err := repo.App.GoogleOAuth.Exchange(context.Background(), code)
 	if err != nil {
 		return nil, fmt.Errorf("code exchange wrong: %s", err.Error())
 	}
@@ -117,28 +126,39 @@ func (repo *DBRepo) getUserDataFromGoogle(code string) ([]byte, error) {
 }
 
 func (repo *DBRepo) validateGoogleJwt(jwt string) (bool, string) {
-	response, err := http.Get(oauthGoogleUrlAPI + jwt)
+	ctx, span := tracing.Start(repo.baseContext(), "google token validation")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oauthGoogleUrlAPI+jwt, nil)
 	if err != nil {
-		log.Println(fmt.Errorf("failed getting user info: %s", err.Error()))
+		tracing.Fail(span, err)
+		slog.Error("failed getting user info", "err", err)
+		return false, ""
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		tracing.Fail(span, err)
+		slog.Error("failed getting user info", "err", err)
 		return false, ""
 	}
 	defer response.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode))
 	content, err := io.ReadAll(response.Body)
 	if err != nil {
-		log.Println(fmt.Errorf("failed read response: %s", err.Error()))
+		tracing.Fail(span, err)
+		slog.Error("failed reading user info", "err", err)
 		return false, ""
 	}
-	log.Println(string(content))
 	var g models.GoogleUserResult
 
 	err = json.Unmarshal(content, &g)
 	if err != nil {
-		log.Println(err)
+		tracing.Fail(span, err)
+		slog.Error("can't decode google user", "err", err)
 		return false, ""
 	}
-	log.Printf("%+v", g)
-	_, err = repo.DB.GetUserById(g.Id)
-	if err != nil {
+	user, err := repo.DB.GetUserById(g.Id)
+	if err != nil || !user.Active() {
 		return false, ""
 	}
 
@@ -5,20 +5,28 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
 	"github.com/SeatSnobAri/seatflipsite/internal/config"
 	"github.com/SeatSnobAri/seatflipsite/internal/driver"
 	"github.com/SeatSnobAri/seatflipsite/internal/forms"
 	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/history"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/metrics"
 	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/outbox"
 	"github.com/SeatSnobAri/seatflipsite/internal/render"
 	"github.com/SeatSnobAri/seatflipsite/internal/repository"
 	"github.com/SeatSnobAri/seatflipsite/internal/repository/dbrepo"
+	"github.com/SeatSnobAri/seatflipsite/internal/rules"
 	"github.com/SeatSnobAri/seatflipsite/internal/templates"
-	"github.com/redis/go-redis/v9"
-	"log"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"log/slog"
 	"net/http"
 	"strconv"
-	"time"
 )
 
 // Repo is the repository
@@ -29,6 +37,7 @@ var app *config.AppConfig
 type DBRepo struct {
 	App *config.AppConfig
 	DB  repository.DatabaseRepo
+	ctx context.Context
 }
 
 // NewHandlers creates the handlers
@@ -45,6 +54,26 @@ func NewPostgresqlHandlers(db *driver.DB, a *config.AppConfig) *DBRepo {
 	}
 }
 
+// withContext returns a copy of the handlers bound to ctx, so the queries, live cart calls and
+// broadcasts they make are traced as part of it. They aren't cancelled with it.
+func (repo *DBRepo) withContext(ctx context.Context) *DBRepo {
+	ctx = context.WithoutCancel(ctx)
+	return &DBRepo{App: repo.App, DB: repo.DB.WithContext(ctx), ctx: ctx}
+}
+
+// baseContext is the context the handlers are bound to, or the background context
+func (repo *DBRepo) baseContext() context.Context {
+	if repo.ctx == nil {
+		return context.Background()
+	}
+	return repo.ctx
+}
+
+// auditEvent starts an audit event for the signed in user
+func (repo *DBRepo) auditEvent(r *http.Request, action string) *audit.Event {
+	return audit.New(r, repo.App.Session.GetString(r.Context(), "user_id"), action)
+}
+
 // Home is the home page handler
 func (repo *DBRepo) Home(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "home.page.gohtml", &templates.TemplateData{})
@@ -52,6 +81,9 @@ func (repo *DBRepo) Home(w http.ResponseWriter, r *http.Request) {
 
 // Logout logs a user out
 func (repo *DBRepo) Logout(w http.ResponseWriter, r *http.Request) {
+	userID := repo.App.Session.GetString(r.Context(), "user_id")
+	audit.New(r, userID, audit.Logout).Target("user", userID).Record(repo.DB)
+
 	_ = repo.App.Session.Destroy(r.Context())
 	_ = repo.App.Session.RenewToken(r.Context())
 
@@ -62,23 +94,29 @@ func (repo *DBRepo) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	id := repo.App.Session.Get(r.Context(), "user_id")
 	user, err := repo.DB.GetUserById(id.(string))
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Error("can't get user from session", "err", err)
 		repo.App.Session.Put(r.Context(), "error", "can't get user from session")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	// get all redis stuff
-	rows, err := repo.sendCurrentRedis()
+	// get all live carts
+	rows, err := repo.liveCarts(r.Context())
 	if err != nil {
-		log.Println(err)
-		repo.App.Session.Put(r.Context(), "error", "can't get redis data")
+		logging.FromContext(r.Context()).Error("can't get live carts", "err", err)
+		repo.App.Session.Put(r.Context(), "error", "can't get live carts")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	budgets, err := repo.DB.BudgetStatus(user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't get budgets", "err", err)
+	}
+
 	data := make(map[string]interface{})
 	data["user"] = user
 	data["rows"] = rows
+	data["budgets"] = budgets
 	render.Template(w, r, "dashboard.page.gohtml", &templates.TemplateData{
 		Data: data,
 	})
@@ -133,40 +171,94 @@ func (repo *DBRepo) PostSignUp(w http.ResponseWriter, r *http.Request) {
 	}
 	err = repo.DB.AddUser(user)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Error("can't add user", "err", err)
 		repo.App.Session.Put(r.Context(), "error", "problem adding user")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	audit.New(r, user.Id, audit.SignUp).Target("user", user.Id).Change(nil, user).Record(repo.DB)
 	repo.App.Session.Put(r.Context(), "user", user)
-	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+	repo.App.Session.Put(r.Context(), "flash", "Your account is waiting for an admin to approve it")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 func (repo *DBRepo) Broker(w http.ResponseWriter, r *http.Request) {
-	var jwt string
-	user_id := app.Session.Get(r.Context(), "user_id")
-	if user_id == "" {
-		jwt = r.URL.Query().Get("token")
-		v, _ := repo.validateGoogleJwt(jwt)
+	// count the action by how it was answered
+	ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+	w = ww
+	action := "unknown"
+	defer func() {
+		metrics.BrokerAction(action, ww.Status())
+	}()
+
+	// the span is named for the action once the payload has been read
+	ctx, span := tracing.Start(r.Context(), "broker")
+	defer func() {
+		span.SetName("broker " + action)
+		span.SetAttributes(attribute.String("broker.action", action))
+		if ww.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+		}
+		span.End()
+	}()
+	r = r.WithContext(ctx)
+	repo = repo.withContext(ctx)
+
+	// a session outlives an account being disabled, so the user is checked on every call
+	userID := app.Session.GetString(r.Context(), "user_id")
+	if userID != "" {
+		user, err := repo.DB.GetUserById(userID)
+		if err != nil || !user.Active() {
+			helpers.ErrorJSON(w, errors.New("account is not active"), http.StatusUnauthorized)
+			return
+		}
+	}
+	if token := helpers.BearerToken(r); userID == "" && token != "" {
+		user, err := repo.DB.GetUserByAPIToken(helpers.HashAPIToken(token))
+		if err != nil || !user.Active() {
+			helpers.ErrorJSON(w, errors.New("invalid api token"), http.StatusUnauthorized)
+			return
+		}
+		userID = user.ID
+	}
+	if userID == "" {
+		jwt := r.URL.Query().Get("token")
+		v, id := repo.validateGoogleJwt(jwt)
 		if !v {
 			helpers.ErrorJSON(w, errors.New("not valid google"))
 			return
 		}
+		userID = id
 	}
+	logger := logging.FromContext(r.Context()).With("user_id", userID)
+	r = r.WithContext(logging.NewContext(r.Context(), logger))
 
 	var requestPayload models.RequestPayload
 
-	err := helpers.ReadJSON(w, r, &requestPayload)
+	// confirmations can carry a base64 screenshot or PDF
+	err := helpers.ReadJSONLimit(w, r, &requestPayload, maxBrokerBytes)
 	if err != nil {
 		helpers.ErrorJSON(w, err)
 		return
 	}
 
+	switch requestPayload.Action {
+	case "cart", "orders", "buy", "confirm", "extend":
+		action = requestPayload.Action
+	}
+	r = r.WithContext(logging.NewContext(r.Context(), logger.With("action", action)))
+
 	switch requestPayload.Action {
 	case "cart":
-		repo.Produce(w, requestPayload.Produce, requestPayload.User)
+		repo.Produce(w, r, requestPayload.Produce, userID)
+	case "orders":
+		repo.OpenOrders(w)
 	case "buy":
-		repo.Consume(w, requestPayload.Buy)
+		repo.Consume(w, r, requestPayload.Buy, userID)
+	case "confirm":
+		repo.Confirm(w, r, requestPayload.Confirm, userID)
+	case "extend":
+		repo.Extend(w, requestPayload.Extend, userID)
 	//case "va":
 	//	repo.VABuy(w, requestPayload.VA)
 	//case "delete":
@@ -175,14 +267,102 @@ func (repo *DBRepo) Broker(w http.ResponseWriter, r *http.Request) {
 		helpers.ErrorJSON(w, errors.New("unknown action"))
 	}
 }
-func (repo *DBRepo) Produce(w http.ResponseWriter, u models.UflipPayload, user models.UserPayload) {
-	repo.storeInRedis(&u)
 
-	err := repo.DB.InsertCart(u, user)
+// Produce records a cart sent by the agent userID, runs the rules over it and puts it live
+func (repo *DBRepo) Produce(w http.ResponseWriter, r *http.Request, u models.UflipPayload, userID string) {
+	logger := logging.FromContext(r.Context()).With("cart_id", u.UUID)
+	// older extensions don't send quantity yet
+	if u.DefaultQuantity() {
+		logger.Warn("cart sent without quantity, using ticket info", "ticket_info", u.TicketInfo, "quantity", u.Quantity)
+	}
+	err := u.Validate()
 	if err != nil {
 		helpers.ErrorJSON(w, err)
 		return
 	}
+
+	// run the rules before anyone sees the cart
+	enabled, err := repo.DB.EnabledRules()
+	if err != nil {
+		logger.Error("can't get rules", "err", err)
+	}
+	u.Decisions = rules.Evaluate(enabled, u)
+	verdict, decided := rules.Verdict(u.Decisions)
+	declined := decided && verdict.Action == models.RuleDecline
+
+	var order models.SnipeOrder
+	var matched bool
+	var msgs []models.OutboxMessage
+	if !declined {
+		order, matched = repo.matchSnipeOrder(u)
+		if matched {
+			u.SnipeOrderID = order.ID
+		}
+		msgs, err = produceMessages(u)
+		if err != nil {
+			helpers.ErrorJSON(w, err)
+			return
+		}
+	}
+
+	// the cart only goes live and on the dashboards once its row is committed
+	err = repo.DB.InsertCart(u, userID, msgs...)
+	if err != nil {
+		helpers.ErrorJSON(w, err)
+		return
+	}
+	if len(msgs) > 0 {
+		repo.App.Outbox.Notify()
+	}
+	if len(u.Decisions) > 0 {
+		if err = repo.DB.InsertRuleDecisions(u.Decisions); err != nil {
+			logger.Error("can't record rule decisions", "err", err)
+		}
+	}
+	if matched {
+		if err = repo.DB.TagCartWithOrder(u.UUID, order.ID); err != nil {
+			logger.Error("can't tag cart with snipe order", "snipe_order_id", order.ID, "err", err)
+		}
+	}
+
+	repo.cartEvent(u.UUID, models.CartProduced, userID, history.Produced{Cart: u, TTL: cartTTL})
+	repo.recordCart(metrics.CartProduced, u.UUID)
+	if declined {
+		repo.cartEvent(u.UUID, models.CartDeclined, verdict.UserID, verdict)
+	}
+	audit.New(r, userID, audit.Produce).Target("cart", u.UUID).Change(nil, u).Record(repo.DB)
+
+	var p models.JsonResponse
+	p.Error = false
+
+	if declined {
+		p.Message = fmt.Sprintf("cart declined by rule %s", verdict.Explanation())
+		p.Data = map[string]string{"status": "declined"}
+		helpers.WriteJSON(w, http.StatusAccepted, &p)
+		return
+	}
+
+	repo.queueRuleNotifications(u)
+
+	if decided && verdict.Action == models.RuleApprove {
+		repo.queueAutoApprove(u, verdict.UserID, fmt.Sprintf("rule %d", verdict.RuleID), 0)
+	} else if matched && order.AutoApprove {
+		repo.queueAutoApprove(u, order.UserID, fmt.Sprintf("snipe order %d", order.ID), order.ID)
+	}
+
+	p.Message = "we have now produced a cart item for your luxury if you would like to purchase its wonderful contents"
+
+	helpers.WriteJSON(w, http.StatusAccepted, &p)
+}
+
+// produceMessages returns the outbox messages that make a produced cart live and put it on the
+// dashboards, in that order
+func produceMessages(u models.UflipPayload) ([]models.OutboxMessage, error) {
+	set, err := outbox.Set(u.UUID, u, cartTTL)
+	if err != nil {
+		return nil, err
+	}
+
 	data := make(map[string]string)
 
 	data["buy"] = strconv.FormatBool(u.Buy)
@@ -195,72 +375,179 @@ func (repo *DBRepo) Produce(w http.ResponseWriter, u models.UflipPayload, user m
 	data["ticket_price"] = u.TicketPrice
 	data["ticket_total"] = u.TicketTotal
 	data["stock_type"] = u.StockType
+	data["rules"] = ruleSummary(u.Decisions)
+	if u.SnipeOrderID != 0 {
+		data["snipe_order_id"] = strconv.Itoa(u.SnipeOrderID)
+	}
 
-	repo.broadcastMessage("public-channel", "produce", data)
-
-	var p models.JsonResponse
-	p.Error = false
-	p.Message = "we have now produced a cart item for your luxury if you would like to purchase its wonderful contents"
+	produce, err := outbox.Broadcast(u.UUID, "public-channel", "produce", data)
+	if err != nil {
+		return nil, err
+	}
 
-	helpers.WriteJSON(w, http.StatusAccepted, &p)
+	return []models.OutboxMessage{set, produce}, nil
 }
 
-func (repo *DBRepo) Consume(w http.ResponseWriter, u models.BuyPayload) {
-	var msg models.UflipPayload
-	val, err := app.Redis.Get(context.Background(), u.UUID).Result()
-	if err != nil {
-		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+func (repo *DBRepo) Consume(w http.ResponseWriter, r *http.Request, u models.BuyPayload, buyerID string) {
+	buyer, err := repo.DB.GetUserById(buyerID)
+	if err != nil || buyer.AccessLevel < models.AccessBuyer {
+		helpers.ErrorJSON(w, errors.New("you need buyer rights to buy carts"), http.StatusForbidden)
 		return
 	}
-	json.Unmarshal([]byte(val), &msg)
-	msg.Buy = u.Buy
-	json, err := json.Marshal(msg)
+
+	msg, err := repo.liveCart(u.UUID)
 	if err != nil {
-		panic(err)
+		helpers.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	_ = app.Redis.Set(context.Background(), u.UUID, json, redis.KeepTTL)
 
-	err = repo.DB.UpdateCart(true, u.UUID)
+	pending, err := repo.approve(r, msg, buyer, 0)
+	var exceeded *models.BudgetExceededError
+	if errors.As(err, &exceeded) {
+		audit.New(r, buyer.ID, audit.Buy).Target("cart", msg.UUID).
+			Change(msg, map[string]string{"status": "over_budget", "reason": exceeded.Error()}).Record(repo.DB)
+		helpers.ErrorJSON(w, fmt.Errorf("%s; sent to an admin for override", exceeded), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		helpers.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	userId := repo.DB.GetCartUser(u.UUID)
-	data := make(map[string]string)
-	data["message"] = strconv.Itoa(msg.TabId)
 
-	_ = repo.App.WsClient.Trigger("public-channel", fmt.Sprintf("%s", userId), data)
+	status := "bought"
+	if pending {
+		status = "pending"
+	}
+	audit.New(r, buyer.ID, audit.Buy).Target("cart", msg.UUID).
+		Change(msg, map[string]string{"status": status}).Record(repo.DB)
 
 	var response models.JsonResponse
 	response.Error = false
 	response.Message = "updated Buy"
+	if pending {
+		response.Message = "waiting for a second buyer to approve"
+		response.Data = map[string]string{"status": "pending"}
+	}
 
 	helpers.WriteJSON(w, http.StatusAccepted, &response)
 }
 
-func (repo *DBRepo) storeInRedis(msg *models.UflipPayload) {
-	json, err := json.Marshal(msg)
+// approve records buyer approving a live cart and buys it once enough buyers have approved.
+// It reports pending when a second buyer still has to approve. A budget refusal comes back as a
+// *models.BudgetExceededError after the cart has been sent to the admins for override. orderID is the
+// snipe order an automatic approval is made for, or 0. r is nil for automatic approvals.
+func (repo *DBRepo) approve(r *http.Request, msg models.UflipPayload, buyer models.User, orderID int) (bool, error) {
+	// big carts need a second buyer to approve before we buy
+	if repo.App.ApprovalThreshold > 0 {
+		total, err := msg.Total()
+		if err != nil {
+			return false, fmt.Errorf("can't read the cart total %q", msg.TicketTotal)
+		}
+		if total > repo.App.ApprovalThreshold {
+			approvals, err := repo.DB.AddApproval(msg.UUID, buyer.ID)
+			if err != nil {
+				return false, err
+			}
+			if approvals < 2 {
+				repo.cartEvent(msg.UUID, models.CartClaimed, buyer.ID, nil)
+				audit.New(r, buyer.ID, audit.Claim).Target("cart", msg.UUID).
+					Change(nil, map[string]any{"approvals": approvals}).Record(repo.DB)
+				repo.requestSecondApproval(msg, buyer)
+				return true, nil
+			}
+		}
+	}
+
+	err := repo.DB.BuyCart(msg.UUID, buyer.ID, false, orderID)
+	var exceeded *models.BudgetExceededError
+	if errors.As(err, &exceeded) {
+		// let an admin decide whether to buy anyway
+		_ = repo.DB.RequestOverride(msg.UUID, true)
+		return false, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	repo.completeBuy(msg, buyer.ID, false)
+	return false, nil
+}
+
+// liveCart returns a cart that is still held
+func (repo *DBRepo) liveCart(id string) (models.UflipPayload, error) {
+	var msg models.UflipPayload
+	val, err := app.Carts.Get(repo.baseContext(), id)
 	if err != nil {
-		panic(err)
+		return msg, err
 	}
-	if err = repo.App.Redis.Set(context.Background(), msg.UUID, json, 10*time.Minute).Err(); err != nil {
-		panic(err)
+	err = json.Unmarshal(val, &msg)
+	return msg, err
+}
+
+// requestSecondApproval marks the live cart as approved once and asks the other buyers to confirm it
+func (repo *DBRepo) requestSecondApproval(msg models.UflipPayload, buyer models.User) {
+	msg.ApprovedBy = buyer.FirstName
+	out, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("can't encode live cart", "cart_id", msg.UUID, "err", err)
+	} else {
+		_ = app.Carts.Update(repo.baseContext(), msg.UUID, out)
 	}
+
+	data := make(map[string]string)
+	data["uuid"] = msg.UUID
+	data["event_name"] = msg.EventName
+	data["ticket_total"] = msg.TicketTotal
+	data["approved_by"] = buyer.FirstName
+	data["approver_id"] = buyer.ID
+
+	repo.broadcastMessage("public-channel", "approval-needed", data)
 }
-func (repo *DBRepo) sendCurrentRedis() ([]models.UflipPayload, error) {
-	var cursor uint64
+
+// completeBuy runs everything that follows a cart being marked bought: the live cart is flagged,
+// the purchase goes into inventory and the agent's tab is told to check out
+func (repo *DBRepo) completeBuy(msg models.UflipPayload, buyerID string, override bool) {
+	repo.cartEvent(msg.UUID, models.CartBought, buyerID, history.Bought{Override: override})
+	repo.recordCart(metrics.CartBought, msg.UUID)
+
+	msg.Buy = true
+	out, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("can't encode live cart", "cart_id", msg.UUID, "err", err)
+	} else {
+		_ = app.Carts.Update(repo.baseContext(), msg.UUID, out)
+	}
+
+	repo.addToInventory(msg.UUID)
+
+	userId := repo.DB.GetCartUser(msg.UUID)
+	data := make(map[string]string)
+	data["message"] = strconv.Itoa(msg.TabId)
+
+	_ = repo.trigger("public-channel", fmt.Sprintf("%s", userId), data)
+
+	repo.broadcastMessage("public-channel", "bought-row", map[string]string{"uuid": msg.UUID})
+
+	if msg.SnipeOrderID != 0 {
+		repo.broadcastOrder(msg.SnipeOrderID)
+	}
+}
+
+// liveCarts returns every cart that is still held
+func (repo *DBRepo) liveCarts(ctx context.Context) ([]models.UflipPayload, error) {
+	values, err := repo.App.Carts.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	var rows []models.UflipPayload
-	iter := repo.App.Redis.Scan(context.Background(), cursor, "*", 10).Iterator()
-	for iter.Next(context.Background()) {
-		log.Println("keys", iter.Val())
+	for _, v := range values {
 		var msg models.UflipPayload
-		m := repo.App.Redis.Get(context.Background(), iter.Val())
-		json.Unmarshal([]byte(m.Val()), &msg)
+		if err = json.Unmarshal(v, &msg); err != nil {
+			logging.FromContext(ctx).Error("can't decode live cart", "err", err)
+			continue
+		}
 		rows = append(rows, msg)
-
-	}
-	if err := iter.Err(); err != nil {
-		panic(err)
 	}
 	return rows, nil
 }
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/pusher/pusher-http-go/v5"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout is how long each readiness check may take
+const checkTimeout = 2 * time.Second
+
+// pusherCheckInterval is how long a Pusher check is reused for, since each one is an API call
+const pusherCheckInterval = 30 * time.Second
+
+// dependency check statuses
+const (
+	checkOK      = "ok"
+	checkFailing = "failing"
+	checkSkipped = "skipped"
+)
+
+// shuttingDown is set once a graceful shutdown starts, so load balancers stop sending traffic
+var shuttingDown atomic.Bool
+
+// pusherCheck is the last Pusher check
+var pusherCheck struct {
+	sync.Mutex
+	result dependencyCheck
+	at     time.Time
+}
+
+// StartShutdown makes readiness fail from now on
+func StartShutdown() {
+	shuttingDown.Store(true)
+}
+
+// dependencyCheck is how one dependency answered
+type dependencyCheck struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// readiness is the body of /readyz
+type readiness struct {
+	Status       string                     `json:"status"`
+	ShuttingDown bool                       `json:"shutting_down"`
+	Leader       bool                       `json:"leader"`
+	Checks       map[string]dependencyCheck `json:"checks"`
+}
+
+// Healthz reports that the process is up and serving, without looking at its dependencies
+func (repo *DBRepo) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// Readyz checks Postgres and the live cart store at once, each with a timeout, and answers 503 if
+// either is failing or the server is shutting down. Pusher's status is reported too, but an outage
+// there is the same for every instance, so it doesn't take any of them out of the load balancer.
+func (repo *DBRepo) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) error{
+		"postgres":   repo.App.DB.SQL.PingContext,
+		"live_carts": repo.App.Carts.Ping,
+	}
+
+	resp := readiness{
+		Status:       checkOK,
+		ShuttingDown: shuttingDown.Load(),
+		Leader:       repo.App.ExpiryLeader != nil && repo.App.ExpiryLeader.Leading(),
+		Checks:       make(map[string]dependencyCheck),
+	}
+	if resp.ShuttingDown {
+		resp.Status = checkFailing
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		result := repo.checkPusher(r.Context())
+
+		mu.Lock()
+		defer mu.Unlock()
+		resp.Checks["pusher"] = result
+	}()
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			result := runCheck(r.Context(), check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			resp.Checks[name] = result
+			if result.Status != checkOK {
+				resp.Status = checkFailing
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if resp.Status != checkOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	helpers.WriteJSON(w, status, resp)
+}
+
+// runCheck runs one check with checkTimeout, timing it
+func runCheck(ctx context.Context, check func(ctx context.Context) error) dependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	// not every client honours the context, so the check is abandoned rather than waited out
+	go func() {
+		errs <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := dependencyCheck{Status: checkOK, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = checkFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// checkPusher returns the last Pusher check, checking again once it is pusherCheckInterval old
+func (repo *DBRepo) checkPusher(ctx context.Context) dependencyCheck {
+	// pusher isn't configured in development
+	if repo.App.WsClient.AppID == "" {
+		return dependencyCheck{Status: checkSkipped}
+	}
+
+	pusherCheck.Lock()
+	defer pusherCheck.Unlock()
+	if time.Since(pusherCheck.at) >= pusherCheckInterval {
+		pusherCheck.result = runCheck(ctx, repo.pingPusher)
+		pusherCheck.at = time.Now()
+	}
+	return pusherCheck.result
+}
+
+// pingPusher lists channels, which needs both the network and valid credentials
+func (repo *DBRepo) pingPusher(ctx context.Context) error {
+	client := repo.App.WsClient
+	hc := &http.Client{Timeout: checkTimeout}
+	if client.HTTPClient != nil {
+		hc.Transport = client.HTTPClient.Transport
+	}
+	client.HTTPClient = hc
+	_, err := client.Channels(pusher.ChannelsParams{})
+	return err
+}
+
+// Version reports the running build
+func (repo *DBRepo) Version(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]string{
+		"version": repo.App.Version,
+		"commit":  repo.App.Commit,
+		"go":      runtime.Version(),
+	}
+	helpers.WriteJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/history"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// cartTTL is how long a produced cart stays live, and how much longer extending it gives
+	cartTTL = 10 * time.Minute
+	// replayWindow is how far back the replay looks for carts that could still have been live
+	replayWindow = 24 * time.Hour
+	// replayLayout is the format of the replay's datetime-local input
+	replayLayout = "2006-01-02T15:04:05"
+)
+
+// cartEvent appends an event to a cart's history. Data is stored as json.
+func (repo *DBRepo) cartEvent(cartID, eventType, actor string, data any) {
+	e := models.CartEvent{
+		CartID: cartID,
+		Type:   eventType,
+		Actor:  actor,
+	}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			slog.Error("can't encode cart event", "cart_id", cartID, "event", eventType, "err", err)
+		}
+		e.Data = b
+	}
+
+	err := repo.DB.InsertCartEvent(e)
+	if err != nil {
+		slog.Error("can't record cart event", "cart_id", cartID, "event", eventType, "err", err)
+	}
+}
+
+// Extend is the broker action an agent sends to keep a live cart for another cartTTL
+func (repo *DBRepo) Extend(w http.ResponseWriter, p models.ExtendPayload, userID string) {
+	if repo.DB.GetCartUser(p.UUID) != userID {
+		helpers.ErrorJSON(w, errors.New("only the agent who found the cart can extend it"), http.StatusForbidden)
+		return
+	}
+
+	ok, err := repo.App.Carts.Extend(repo.baseContext(), p.UUID, cartTTL)
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		helpers.ErrorJSON(w, errors.New("that cart has expired"), http.StatusGone)
+		return
+	}
+
+	repo.cartEvent(p.UUID, models.CartExtended, userID, history.Extended{TTL: cartTTL})
+
+	var resp models.JsonResponse
+	resp.Error = false
+	resp.Message = "cart extended"
+	resp.Data = map[string]string{"expires_at": time.Now().Add(cartTTL).Format(time.RFC3339)}
+
+	helpers.WriteJSON(w, http.StatusAccepted, &resp)
+}
+
+// AdminReplay shows the dashboard as it was at a moment in the past, rebuilt from cart events.
+// With a cart in the query string it also shows that cart's whole history.
+func (repo *DBRepo) AdminReplay(w http.ResponseWriter, r *http.Request) {
+	at := time.Now()
+	if q := r.URL.Query().Get("at"); q != "" {
+		t, err := parseReplayTime(q)
+		if err != nil {
+			repo.App.Session.Put(r.Context(), "error", "Choose a valid date and time")
+			http.Redirect(w, r, "/admin/replay", http.StatusSeeOther)
+			return
+		}
+		at = t
+	}
+
+	events, err := repo.DB.CartEventsAt(at, replayWindow)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["carts"] = history.Replay(events, at)
+	data["at"] = at
+
+	if id := r.URL.Query().Get("cart"); id != "" {
+		all, err := repo.DB.CartEvents(id)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		data["cart"] = history.Project(all)
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["at"] = at.Local().Format(replayLayout)
+
+	render.Template(w, r, "replay.page.gohtml", &templates.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// parseReplayTime reads a datetime-local value, with or without seconds, in the server's time zone
+func parseReplayTime(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(replayLayout, s, time.Local)
+	if err != nil {
+		t, err = time.ParseInLocation(orderExpiryLayout, s, time.Local)
+	}
+	return t, err
+}
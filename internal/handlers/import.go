@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/forms"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/importer"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sort"
+)
+
+// maxImportSize is the largest csv file accepted by the upload page
+const maxImportSize = 10 << 20
+
+// importTokenKey holds the token of the last upload dry run in the session
+const importTokenKey = "import_dry_run"
+
+// AdminImport displays the purchase import page
+func (repo *DBRepo) AdminImport(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["fields"] = importer.Fields
+	data["mapping"] = importer.DefaultMapping()
+
+	render.Template(w, r, "import.page.gohtml", &templates.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// PostAdminImport imports an uploaded csv of historical purchases, or dry runs it
+func (repo *DBRepo) PostAdminImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	err := r.ParseMultipartForm(maxImportSize)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			repo.App.Session.Put(r.Context(), "error", "file is too large")
+		} else {
+			repo.App.Session.Put(r.Context(), "error", "can't read the upload: "+err.Error())
+		}
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	mapping := importer.DefaultMapping()
+	for _, field := range importer.Fields {
+		if h := form.Get("map_" + field); h != "" {
+			_ = mapping.Set(field, h)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["fields"] = importer.Fields
+	data["mapping"] = mapping
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		form.Errors.Add("file", "Choose a csv file to import")
+		render.Template(w, r, "import.page.gohtml", &templates.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+	defer file.Close()
+
+	agent, _ := repo.App.Session.Get(r.Context(), "user_id").(string)
+	dryRun := form.Get("commit") != "true"
+
+	token, err := dryRunToken(file, mapping)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// a file is only imported for real with the mapping it was dry run with
+	if !dryRun && (form.Get("dry_run_token") != token ||
+		repo.App.Session.GetString(r.Context(), importTokenKey) != token) {
+		form.Errors.Add("file", "Dry run this file with this mapping before importing it")
+		render.Template(w, r, "import.page.gohtml", &templates.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	result, err := importer.Purchases(repo.DB, file, mapping, agent, dryRun)
+	if err != nil {
+		form.Errors.Add("file", err.Error())
+	}
+	if dryRun && err == nil {
+		repo.App.Session.Put(r.Context(), importTokenKey, token)
+		data["dry_run_token"] = token
+	}
+	if !dryRun {
+		repo.App.Session.Remove(r.Context(), importTokenKey)
+		audit.New(r, agent, audit.Import).Target("file", header.Filename).Change(nil, result).Record(repo.DB)
+	}
+
+	data["result"] = result
+
+	render.Template(w, r, "import.page.gohtml", &templates.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// dryRunToken identifies an upload by its contents and mapping, and rewinds file for reading
+func dryRunToken(file multipart.File, mapping importer.Mapping) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	fields := make([]string, 0, len(mapping))
+	for field := range mapping {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		fmt.Fprintf(h, "\n%s=%s", field, mapping[field])
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
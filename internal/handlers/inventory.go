@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/forms"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// addToInventory records a bought cart in the inventory ledger
+func (repo *DBRepo) addToInventory(cartID string) {
+	cart, err := repo.DB.GetCartById(cartID)
+	if err != nil {
+		slog.Error("can't get bought cart for inventory", "cart_id", cartID, "err", err)
+		return
+	}
+	_, err = repo.DB.InsertInventoryFromCart(cart)
+	if err != nil {
+		slog.Error("can't add bought cart to inventory", "cart_id", cartID, "err", err)
+	}
+}
+
+// AdminInventory displays the inventory ledger
+func (repo *DBRepo) AdminInventory(w http.ResponseWriter, r *http.Request) {
+	items, err := repo.DB.AllInventory()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	attachments, err := repo.DB.InventoryAttachments()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["items"] = items
+	data["attachments"] = attachments
+	data["states"] = models.InventoryStates
+
+	render.Template(w, r, "inventory.page.gohtml", &templates.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// PostInventoryState moves an inventory item to a new state
+func (repo *DBRepo) PostInventoryState(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	item, err := repo.DB.GetInventoryById(id)
+	if err != nil {
+		repo.App.Session.Put(r.Context(), "error", "can't find inventory item")
+		http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("state")
+	form.IsFloat("fees", "listing_price", "sale_price")
+
+	state := form.Get("state")
+	if state != item.State && !item.CanTransition(state) {
+		form.Errors.Add("state", models.ErrInvalidTransition.Error())
+	}
+	if state == models.InventoryListed && form.Get("listing_price") == "" && item.ListingPrice == 0 {
+		form.Errors.Add("listing_price", "A listing price is required to list an item")
+	}
+	if state == models.InventorySold && form.Get("sale_price") == "" && item.SalePrice == 0 {
+		form.Errors.Add("sale_price", "A sale price is required to sell an item")
+	}
+
+	if !form.Valid() {
+		repo.App.Session.Put(r.Context(), "error", fmt.Sprintf("Item %d: %s", item.ID, form.Errors.First()))
+		http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+		return
+	}
+
+	before := item
+	item.State = state
+	if form.Has("fees") {
+		item.Fees, _ = strconv.ParseFloat(form.Get("fees"), 64)
+	}
+	if form.Has("listing_price") {
+		item.ListingPrice, _ = strconv.ParseFloat(form.Get("listing_price"), 64)
+	}
+	if form.Has("sale_price") {
+		item.SalePrice, _ = strconv.ParseFloat(form.Get("sale_price"), 64)
+	}
+
+	err = repo.DB.UpdateInventory(item)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.InventoryEdit).Target("inventory", strconv.Itoa(item.ID)).Change(before, item).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Item %d is now %s", item.ID, item.State))
+	http.Redirect(w, r, "/admin/inventory", http.StatusSeeOther)
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/export"
+	"github.com/SeatSnobAri/seatflipsite/internal/forms"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/jobs"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/storage"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"github.com/go-chi/chi/v5"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// job kinds
+const (
+	JobExpireOrders    = "snipe_orders.expire"
+	JobCleanupSessions = "sessions.cleanup"
+	JobPrune           = "maintenance.prune"
+	JobRuleNotify      = "rules.notify"
+	JobAutoApprove     = "rules.auto_approve"
+	JobExport          = "export.generate"
+)
+
+const (
+	// recentJobs is how many jobs the jobs page shows
+	recentJobs = 100
+	// liveWait is how long an auto-approve job waits for its cart to go live before it's retried
+	liveWait = 5 * time.Second
+	// keepFinished is how long finished jobs and dispatched outbox messages are kept
+	keepFinished = 7 * 24 * time.Hour
+)
+
+// exports are the exports that can be generated in the background, by name
+var exports = map[string]exportFunc{
+	"carts":     export.Carts,
+	"purchases": export.Purchases,
+}
+
+// exportJob is the payload of an export.generate job
+type exportJob struct {
+	Name   string              `json:"name"`
+	Format string              `json:"format"`
+	Filter models.ExportFilter `json:"filter"`
+}
+
+// exportResult is the result of an export.generate job; the file is kept in the attachment store
+type exportResult struct {
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	Filename string `json:"filename"`
+	Format   string `json:"format"`
+}
+
+// RegisterJobs sets the handlers and schedules of the background jobs on p
+func (repo *DBRepo) RegisterJobs(p *jobs.Pool) error {
+	p.Register(JobExpireOrders, repo.expireOrdersJob)
+	p.Register(JobCleanupSessions, repo.cleanupSessionsJob)
+	p.Register(JobPrune, repo.pruneJob)
+	p.Register(JobRuleNotify, repo.ruleNotifyJob)
+	p.Register(JobAutoApprove, repo.autoApproveJob)
+	p.Register(JobExport, repo.exportJob)
+
+	if err := p.Schedule("expire snipe orders", "* * * * *", JobExpireOrders, nil); err != nil {
+		return err
+	}
+	if err := p.Schedule("clean up sessions", "*/15 * * * *", JobCleanupSessions, nil); err != nil {
+		return err
+	}
+	return p.Schedule("prune finished work", "30 4 * * *", JobPrune, nil)
+}
+
+// expireOrdersJob closes snipe orders that have run out of time and tells the agents
+func (repo *DBRepo) expireOrdersJob(ctx context.Context, j models.Job) (any, error) {
+	ids, err := repo.DB.ExpireSnipeOrders()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		repo.broadcastOrder(id)
+	}
+	return map[string]int{"expired": len(ids)}, nil
+}
+
+// cleanupSessionsJob deletes expired sessions
+func (repo *DBRepo) cleanupSessionsJob(ctx context.Context, j models.Job) (any, error) {
+	n, err := repo.DB.DeleteExpiredSessions()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int64{"deleted": n}, nil
+}
+
+// pruneJob deletes finished jobs and dispatched outbox messages that are older than keepFinished
+func (repo *DBRepo) pruneJob(ctx context.Context, j models.Job) (any, error) {
+	before := time.Now().Add(-keepFinished)
+
+	finished, err := repo.DB.PruneJobs(before)
+	if err != nil {
+		return nil, err
+	}
+	dispatched, err := repo.DB.PruneOutbox(before)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int64{"jobs": finished, "outbox": dispatched}, nil
+}
+
+// ruleNotifyJob sends the notifications for a produced cart's notify rules
+func (repo *DBRepo) ruleNotifyJob(ctx context.Context, j models.Job) (any, error) {
+	var u models.UflipPayload
+	if err := json.Unmarshal(j.Payload, &u); err != nil {
+		return nil, err
+	}
+	return nil, repo.notifyRuleOwners(u)
+}
+
+// autoApproveJob approves a cart for the rule or snipe order that matched it. The cart can only be
+// bought once it's live, so the job fails, to be retried, if the outbox hasn't got it there in time.
+func (repo *DBRepo) autoApproveJob(ctx context.Context, j models.Job) (any, error) {
+	var p autoApproveJob
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, liveWait)
+	defer cancel()
+	for {
+		_, err := repo.liveCart(p.Cart.UUID)
+		if err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("cart %s is not live: %w", p.Cart.UUID, err)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+
+	repo.autoApprove(p.Cart, p.OwnerID, p.Reason, p.OrderID)
+	return nil, nil
+}
+
+// exportJob writes an export to the attachment store, so a large export doesn't tie up a request
+func (repo *DBRepo) exportJob(ctx context.Context, j models.Job) (any, error) {
+	var p exportJob
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return nil, err
+	}
+	fn, ok := exports[p.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown export %q", p.Name)
+	}
+
+	f, err := os.CreateTemp("", "seatflip-export-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	if err = fn(ctx, repo.DB, io.MultiWriter(f, h), p.Format, p.Filter); err != nil {
+		return nil, err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	key := hex.EncodeToString(h.Sum(nil))
+	if err = repo.App.Storage.Put(ctx, key, f); err != nil {
+		return nil, err
+	}
+
+	return exportResult{
+		Key:      key,
+		Size:     size,
+		Filename: fmt.Sprintf("%s-%s.%s", p.Name, j.CreatedAt.Format("20060102-150405"), p.Format),
+		Format:   p.Format,
+	}, nil
+}
+
+// AdminJobs displays recent background jobs and the form to queue an export
+func (repo *DBRepo) AdminJobs(w http.ResponseWriter, r *http.Request) {
+	repo.renderJobs(w, r, forms.New(nil))
+}
+
+func (repo *DBRepo) renderJobs(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	recent, err := repo.DB.RecentJobs(recentJobs)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["jobs"] = recent
+	data["states"] = models.InventoryStates
+
+	render.Template(w, r, "jobs.page.gohtml", &templates.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostExportJob queues an export to be generated in the background
+func (repo *DBRepo) PostExportJob(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "format")
+	form.IsIn("name", "carts", "purchases")
+	form.IsIn("format", export.FormatCSV, export.FormatNDJSON)
+	form.IsDate("from", reportDateLayout)
+	form.IsDate("to", reportDateLayout)
+
+	if !form.Valid() {
+		repo.renderJobs(w, r, form)
+		return
+	}
+
+	f, err := readExportFilter(r.PostForm)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	p := exportJob{Name: form.Get("name"), Format: form.Get("format"), Filter: f}
+	id, err := repo.App.Jobs.Enqueue(JobExport, p, repo.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.Export).Target("export", p.Name).
+		Change(nil, map[string]any{"format": p.Format, "filter": f, "job": id}).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Export queued as job %d", id))
+	http.Redirect(w, r, "/admin/jobs", http.StatusSeeOther)
+}
+
+// JobDownload downloads the file generated by a finished export job
+func (repo *DBRepo) JobDownload(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	j, err := repo.DB.GetJobById(id)
+	if err != nil || j.Kind != JobExport || j.Status != models.JobDone {
+		http.NotFound(w, r)
+		return
+	}
+
+	var res exportResult
+	if err = json.Unmarshal(j.Result, &res); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	f, err := repo.App.Storage.Get(r.Context(), res.Key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", export.ContentType(res.Format))
+	w.Header().Set("Content-Length", strconv.FormatInt(res.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", res.Filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	_, err = io.Copy(w, f)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("can't send job result", "err", err)
+	}
+}
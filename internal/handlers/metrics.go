@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/SeatSnobAri/seatflipsite/internal/metrics"
+	"log/slog"
+	"time"
+)
+
+// recordCart counts a cart reaching a step of its lifecycle, labelled with the agent's team and the
+// stock type. Bought and expired carts also record how long they waited for that decision.
+func (repo *DBRepo) recordCart(event, cartID string) {
+	c, err := repo.DB.GetCartById(cartID)
+	if err != nil {
+		slog.Error("metrics: can't get cart", "cart_id", cartID, "err", err)
+		return
+	}
+
+	team := "none"
+	if u, err := repo.DB.GetUserById(c.UserID); err == nil && u.Team != "" {
+		team = u.Team
+	}
+
+	if event == metrics.CartProduced {
+		metrics.Cart(event, team, c.StockType)
+		return
+	}
+	metrics.CartDecided(event, team, c.StockType, time.Since(c.CreatedAt))
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/forms"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/rules"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// orderExpiryLayout is the format of a datetime-local input
+	orderExpiryLayout = "2006-01-02T15:04"
+	// defaultOrderLifetime is how long an order stays open when no expiry is given
+	defaultOrderLifetime = 24 * time.Hour
+	// recentOrders is how many orders the orders page shows
+	recentOrders = 200
+)
+
+// matchSnipeOrder returns the oldest open snipe order the cart satisfies
+func (repo *DBRepo) matchSnipeOrder(u models.UflipPayload) (models.SnipeOrder, bool) {
+	orders, err := repo.DB.OpenSnipeOrders()
+	if err != nil {
+		slog.Error("can't get open snipe orders", "err", err)
+		return models.SnipeOrder{}, false
+	}
+
+	for _, o := range orders {
+		if ok, _ := rules.Match(o.Rule(), u); ok {
+			return o, true
+		}
+	}
+	return models.SnipeOrder{}, false
+}
+
+// broadcastOrder pushes the current state of a snipe order to the agents
+func (repo *DBRepo) broadcastOrder(id int) {
+	o, err := repo.DB.GetSnipeOrderById(id)
+	if err != nil {
+		slog.Error("can't get snipe order", "snipe_order_id", id, "err", err)
+		return
+	}
+
+	data := make(map[string]string)
+	data["id"] = strconv.Itoa(o.ID)
+	data["status"] = o.Status
+	data["event_name"] = o.EventName
+	data["venue"] = o.Venue
+	data["sections"] = o.Sections
+	data["max_price"] = fmt.Sprintf("%.2f", o.MaxPrice)
+	data["quantity"] = strconv.Itoa(o.Quantity)
+	data["notes"] = o.Notes
+	data["expires_at"] = o.ExpiresAt.Format(time.RFC3339)
+
+	repo.broadcastMessage("public-channel", "snipe-order", data)
+}
+
+// OpenOrders is the broker action that lists open snipe orders for agents
+func (repo *DBRepo) OpenOrders(w http.ResponseWriter) {
+	orders, err := repo.DB.OpenSnipeOrders()
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var resp models.JsonResponse
+	resp.Error = false
+	resp.Data = orders
+
+	helpers.WriteJSON(w, http.StatusOK, &resp)
+}
+
+// AdminOrders displays snipe orders and the form to post one
+func (repo *DBRepo) AdminOrders(w http.ResponseWriter, r *http.Request) {
+	repo.renderOrders(w, r, forms.New(nil), models.SnipeOrder{})
+}
+
+func (repo *DBRepo) renderOrders(w http.ResponseWriter, r *http.Request, form *forms.Form, editing models.SnipeOrder) {
+	orders, err := repo.DB.AllSnipeOrders(recentOrders)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["orders"] = orders
+	data["order"] = editing
+
+	render.Template(w, r, "orders.page.gohtml", &templates.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// EditOrder displays an open snipe order for editing
+func (repo *DBRepo) EditOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	o, err := repo.DB.GetSnipeOrderById(id)
+	if err != nil {
+		repo.App.Session.Put(r.Context(), "error", "can't find that order")
+		http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(url.Values{
+		"event_name":   {o.EventName},
+		"venue":        {o.Venue},
+		"sections":     {o.Sections},
+		"quantity":     {strconv.Itoa(o.Quantity)},
+		"max_price":    {strconv.FormatFloat(o.MaxPrice, 'f', 2, 64)},
+		"notes":        {o.Notes},
+		"auto_approve": {strconv.FormatBool(o.AutoApprove)},
+		"expires_at":   {o.ExpiresAt.Local().Format(orderExpiryLayout)},
+	})
+
+	repo.renderOrders(w, r, form, o)
+}
+
+// PostOrder posts a new snipe order, or updates an open one
+func (repo *DBRepo) PostOrder(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID := repo.App.Session.GetString(r.Context(), "user_id")
+	user, err := repo.DB.GetUserById(userID)
+	if err != nil || user.AccessLevel < models.AccessBuyer {
+		repo.App.Session.Put(r.Context(), "error", "You need buyer rights to post orders")
+		http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+		return
+	}
+
+	var existing models.SnipeOrder
+	if id := chi.URLParam(r, "id"); id != "" {
+		orderID, _ := strconv.Atoi(id)
+		existing, err = repo.DB.GetSnipeOrderById(orderID)
+		if err != nil || existing.Status != models.OrderOpen {
+			repo.App.Session.Put(r.Context(), "error", "only open orders can be changed")
+			http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+			return
+		}
+		if existing.UserID != userID && user.AccessLevel < models.AccessAdmin {
+			repo.App.Session.Put(r.Context(), "error", "only the order's owner or an admin can change it")
+			http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+			return
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("event_name", "quantity")
+	form.IsInt("quantity")
+	form.IsFloat("max_price")
+	form.IsDate("expires_at", orderExpiryLayout)
+
+	if !form.Valid() {
+		repo.renderOrders(w, r, form, existing)
+		return
+	}
+
+	o, err := orderFromForm(form)
+	if err != nil {
+		form.Errors.Add("expires_at", err.Error())
+		repo.renderOrders(w, r, form, existing)
+		return
+	}
+
+	if existing.ID != 0 {
+		o.ID = existing.ID
+		o.UserID = existing.UserID
+		err = repo.DB.UpdateSnipeOrder(o)
+	} else {
+		o.UserID = userID
+		o.ID, err = repo.DB.InsertSnipeOrder(o)
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var before any
+	if existing.ID != 0 {
+		before = existing
+	}
+	audit.New(r, userID, audit.OrderSave).Target("order", strconv.Itoa(o.ID)).Change(before, o).Record(repo.DB)
+
+	repo.broadcastOrder(o.ID)
+
+	repo.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Order #%d saved", o.ID))
+	http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+}
+
+// CancelOrder closes an open snipe order
+func (repo *DBRepo) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := repo.DB.GetUserById(repo.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil || user.AccessLevel < models.AccessBuyer {
+		repo.App.Session.Put(r.Context(), "error", "You need buyer rights to cancel orders")
+		http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+		return
+	}
+	order, err := repo.DB.GetSnipeOrderById(id)
+	if err != nil || (order.UserID != user.ID && user.AccessLevel < models.AccessAdmin) {
+		repo.App.Session.Put(r.Context(), "error", "only the order's owner or an admin can cancel it")
+		http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+		return
+	}
+
+	err = repo.DB.SetSnipeOrderStatus(id, models.OrderCancelled)
+	if errors.Is(err, models.ErrOrderNotOpen) {
+		repo.App.Session.Put(r.Context(), "error", "only open orders can be cancelled")
+		http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.OrderCancel).Target("order", strconv.Itoa(id)).Record(repo.DB)
+
+	repo.broadcastOrder(id)
+
+	repo.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Order #%d cancelled", id))
+	http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+}
+
+// orderFromForm builds a snipe order from a validated form
+func orderFromForm(form *forms.Form) (models.SnipeOrder, error) {
+	o := models.SnipeOrder{
+		EventName:   form.Get("event_name"),
+		Venue:       form.Get("venue"),
+		Sections:    form.Get("sections"),
+		Notes:       form.Get("notes"),
+		AutoApprove: form.Get("auto_approve") == "true",
+		Status:      models.OrderOpen,
+		ExpiresAt:   time.Now().Add(defaultOrderLifetime),
+	}
+	o.Quantity, _ = strconv.Atoi(form.Get("quantity"))
+	o.MaxPrice, _ = strconv.ParseFloat(form.Get("max_price"), 64)
+
+	if form.Has("expires_at") {
+		t, err := time.ParseInLocation(orderExpiryLayout, form.Get("expires_at"), time.Local)
+		if err != nil {
+			return o, err
+		}
+		if t.Before(time.Now()) {
+			return o, errors.New("The expiry must be in the future")
+		}
+		o.ExpiresAt = t
+	}
+
+	return o, nil
+}
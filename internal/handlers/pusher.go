@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
 	"github.com/pusher/pusher-http-go/v5"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
@@ -25,7 +27,7 @@ func (repo *DBRepo) PusherAuth(w http.ResponseWriter, r *http.Request) {
 
 	response, err := app.WsClient.AuthorizePresenceChannel(params, presenceData)
 	if err != nil {
-		log.Println(err)
+		logging.FromContext(r.Context()).Error("can't authorize presence channel", "err", err)
 		return
 	}
 
@@ -41,13 +43,20 @@ func (repo *DBRepo) SendPrivateMessage(w http.ResponseWriter, r *http.Request) {
 	data := make(map[string]string)
 	data["message"] = msg
 
-	_ = repo.App.WsClient.Trigger(fmt.Sprintf("private-channel-%s", id), "private-message", data)
+	_ = repo.trigger(fmt.Sprintf("private-channel-%s", id), "private-message", data)
 
 }
 
+// trigger sends an event to a Pusher channel, traced as part of what the handlers are bound to
+func (repo *DBRepo) trigger(channel, event string, data any) error {
+	return tracing.Trigger(repo.baseContext(), channel, event, func() error {
+		return repo.App.WsClient.Trigger(channel, event, data)
+	})
+}
+
 func (repo *DBRepo) broadcastMessage(channel, messageType string, data map[string]string) {
-	err := app.WsClient.Trigger(channel, messageType, data)
+	err := repo.trigger(channel, messageType, data)
 	if err != nil {
-		log.Println(err)
+		slog.Error("can't broadcast message", "channel", channel, "event", messageType, "err", err)
 	}
 }
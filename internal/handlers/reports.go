@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"net/http"
+	"time"
+)
+
+const reportDateLayout = "2006-01-02"
+
+// readReportFilter builds a report filter from the from, to and period query parameters
+func readReportFilter(r *http.Request) (models.ReportFilter, error) {
+	f := models.ReportFilter{
+		From:   time.Unix(0, 0).UTC(),
+		To:     time.Now().UTC().AddDate(0, 0, 1),
+		Period: "day",
+	}
+
+	q := r.URL.Query()
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse(reportDateLayout, from)
+		if err != nil {
+			return f, errors.New("from must be a date in YYYY-MM-DD format")
+		}
+		f.From = t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse(reportDateLayout, to)
+		if err != nil {
+			return f, errors.New("to must be a date in YYYY-MM-DD format")
+		}
+		// include the whole of the last day
+		f.To = t.AddDate(0, 0, 1)
+	}
+	switch period := q.Get("period"); period {
+	case "":
+	case "day", "week", "month":
+		f.Period = period
+	default:
+		return f, errors.New("period must be day, week or month")
+	}
+
+	return f, nil
+}
+
+// AdminReports displays profit and loss reports
+func (repo *DBRepo) AdminReports(w http.ResponseWriter, r *http.Request) {
+	f, err := readReportFilter(r)
+	if err != nil {
+		repo.App.Session.Put(r.Context(), "error", err.Error())
+		http.Redirect(w, r, "/admin/reports", http.StatusSeeOther)
+		return
+	}
+
+	events, err := repo.DB.EventReport(f)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	agents, err := repo.DB.AgentReport(f)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	teams, err := repo.DB.TeamReport(f)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	periods, err := repo.DB.PeriodReport(f)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["from"] = r.URL.Query().Get("from")
+	stringMap["to"] = r.URL.Query().Get("to")
+	stringMap["period"] = f.Period
+
+	data := make(map[string]interface{})
+	data["events"] = events
+	data["agents"] = agents
+	data["teams"] = teams
+	data["periods"] = periods
+
+	render.Template(w, r, "reports.page.gohtml", &templates.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// EventReportJSON returns profit and loss per event as json
+func (repo *DBRepo) EventReportJSON(w http.ResponseWriter, r *http.Request) {
+	repo.reportJSON(w, r, func(f models.ReportFilter) (any, error) {
+		return repo.DB.EventReport(f)
+	})
+}
+
+// AgentReportJSON returns spend and profit and loss per agent as json
+func (repo *DBRepo) AgentReportJSON(w http.ResponseWriter, r *http.Request) {
+	repo.reportJSON(w, r, func(f models.ReportFilter) (any, error) {
+		return repo.DB.AgentReport(f)
+	})
+}
+
+// TeamReportJSON returns spend and profit and loss per team as json
+func (repo *DBRepo) TeamReportJSON(w http.ResponseWriter, r *http.Request) {
+	repo.reportJSON(w, r, func(f models.ReportFilter) (any, error) {
+		return repo.DB.TeamReport(f)
+	})
+}
+
+// PeriodReportJSON returns spend and profit and loss per period as json
+func (repo *DBRepo) PeriodReportJSON(w http.ResponseWriter, r *http.Request) {
+	repo.reportJSON(w, r, func(f models.ReportFilter) (any, error) {
+		return repo.DB.PeriodReport(f)
+	})
+}
+
+// reportJSON reads the report filter, runs report and writes the result
+func (repo *DBRepo) reportJSON(w http.ResponseWriter, r *http.Request, report func(models.ReportFilter) (any, error)) {
+	f, err := readReportFilter(r)
+	if err != nil {
+		helpers.ErrorJSON(w, err)
+		return
+	}
+
+	rows, err := report(f)
+	if err != nil {
+		helpers.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	var resp models.JsonResponse
+	resp.Error = false
+	resp.Data = rows
+
+	helpers.WriteJSON(w, http.StatusOK, &resp)
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/audit"
+	"github.com/SeatSnobAri/seatflipsite/internal/forms"
+	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/render"
+	"github.com/SeatSnobAri/seatflipsite/internal/templates"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// recentDecisions is how many rule decisions the rules page shows
+const recentDecisions = 50
+
+// ruleSummary encodes the decisions for the dashboard, which shows each one with its explanation
+func ruleSummary(decisions []models.RuleDecision) string {
+	if len(decisions) == 0 {
+		return ""
+	}
+
+	var summary []map[string]string
+	for _, d := range decisions {
+		summary = append(summary, map[string]string{
+			"rule_name":   d.RuleName,
+			"action":      d.Action,
+			"explanation": d.Explanation(),
+		})
+	}
+
+	out, err := json.Marshal(summary)
+	if err != nil {
+		slog.Error("can't encode rule decisions", "err", err)
+		return ""
+	}
+	return string(out)
+}
+
+// notifyRuleOwners sends a private message to the owner of every notify rule that matched. It runs
+// as a job, so an error is returned for the job to be retried.
+func (repo *DBRepo) notifyRuleOwners(u models.UflipPayload) error {
+	for _, d := range u.Decisions {
+		if d.Action != models.RuleNotify {
+			continue
+		}
+
+		data := make(map[string]string)
+		data["message"] = fmt.Sprintf("%s %s: %s", u.EventName, u.TicketTotal, d.Explanation())
+		data["uuid"] = u.UUID
+
+		err := repo.trigger(fmt.Sprintf("private-channel-%s", d.UserID), "rule-notify", data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueRuleNotifications queues the notifications for a produced cart's notify rules, if any matched
+func (repo *DBRepo) queueRuleNotifications(u models.UflipPayload) {
+	for _, d := range u.Decisions {
+		if d.Action == models.RuleNotify {
+			_, err := repo.App.Jobs.Enqueue(JobRuleNotify, u, "")
+			if err != nil {
+				slog.Error("can't queue rule notifications", "cart_id", u.UUID, "err", err)
+			}
+			return
+		}
+	}
+}
+
+// autoApproveJob is the payload of a rules.auto_approve job
+type autoApproveJob struct {
+	Cart    models.UflipPayload `json:"cart"`
+	OwnerID string              `json:"owner_id"`
+	Reason  string              `json:"reason"`
+	OrderID int                 `json:"order_id,omitempty"`
+}
+
+// queueAutoApprove queues the approval of a produced cart, which runs once the outbox has made the
+// cart live
+func (repo *DBRepo) queueAutoApprove(u models.UflipPayload, ownerID, reason string, orderID int) {
+	job := autoApproveJob{Cart: u, OwnerID: ownerID, Reason: reason, OrderID: orderID}
+	_, err := repo.App.Jobs.Enqueue(JobAutoApprove, job, "")
+	if err != nil {
+		slog.Error("can't queue auto-approve", "cart_id", u.UUID, "reason", reason, "err", err)
+	}
+}
+
+// autoApprove approves a cart on behalf of ownerID, the owner of the rule or snipe order that approved
+// it. orderID is the snipe order, which must still be open, or 0 for a rule.
+func (repo *DBRepo) autoApprove(u models.UflipPayload, ownerID, reason string, orderID int) {
+	logger := slog.With("cart_id", u.UUID, "reason", reason, "owner_id", ownerID)
+	owner, err := repo.DB.GetUserById(ownerID)
+	if err != nil {
+		logger.Error("auto-approve: can't get owner", "err", err)
+		return
+	}
+	if owner.AccessLevel < models.AccessBuyer {
+		logger.Warn("auto-approve: owner has no buyer rights, not approving")
+		return
+	}
+
+	pending, err := repo.approve(nil, u, owner, orderID)
+	if err != nil {
+		logger.Error("auto-approve failed", "err", err)
+		return
+	}
+
+	status := "bought"
+	if pending {
+		status = "pending"
+	}
+	audit.New(nil, audit.SystemActor, audit.AutoBuy).Target("cart", u.UUID).
+		Change(u, map[string]string{"status": status, "approved_as": owner.ID, "reason": reason}).Record(repo.DB)
+}
+
+// AdminRules displays the rules and their recent decisions
+func (repo *DBRepo) AdminRules(w http.ResponseWriter, r *http.Request) {
+	repo.renderRules(w, r, forms.New(nil))
+}
+
+func (repo *DBRepo) renderRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	all, err := repo.DB.AllRules()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	decisions, err := repo.DB.RecentRuleDecisions(recentDecisions)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = all
+	data["decisions"] = decisions
+	data["actions"] = models.RuleActions
+
+	render.Template(w, r, "rules.page.gohtml", &templates.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostRule adds a rule owned by the current user
+func (repo *DBRepo) PostRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := repo.DB.GetUserById(repo.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil || user.AccessLevel < models.AccessBuyer {
+		http.Error(w, "You need buyer rights to add rules", http.StatusForbidden)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "action")
+	form.IsIn("action", models.RuleActions...)
+	form.IsInt("priority", "min_quantity", "max_quantity")
+	form.IsFloat("min_price", "max_price")
+
+	if !form.Valid() {
+		repo.renderRules(w, r, form)
+		return
+	}
+
+	rule := models.Rule{
+		UserID:    user.ID,
+		Name:      form.Get("name"),
+		Priority:  100,
+		Enabled:   true,
+		Action:    form.Get("action"),
+		EventName: form.Get("event_name"),
+		Venue:     form.Get("venue"),
+		Sections:  form.Get("sections"),
+		StockType: form.Get("stock_type"),
+	}
+	if form.Has("priority") {
+		rule.Priority, _ = strconv.Atoi(form.Get("priority"))
+	}
+	rule.MinPrice, _ = strconv.ParseFloat(form.Get("min_price"), 64)
+	rule.MaxPrice, _ = strconv.ParseFloat(form.Get("max_price"), 64)
+	rule.MinQuantity, _ = strconv.Atoi(form.Get("min_quantity"))
+	rule.MaxQuantity, _ = strconv.Atoi(form.Get("max_quantity"))
+
+	rule.ID, err = repo.DB.InsertRule(rule)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.RuleSave).Target("rule", strconv.Itoa(rule.ID)).Change(nil, rule).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", "Rule added")
+	http.Redirect(w, r, "/admin/rules", http.StatusSeeOther)
+}
+
+// ToggleRule turns a rule on or off
+func (repo *DBRepo) ToggleRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if !repo.canChangeRule(r, id) {
+		http.Error(w, "Only the rule's owner or an admin can change it", http.StatusForbidden)
+		return
+	}
+
+	enabled := r.Form.Get("enabled") == "true"
+	err = repo.DB.SetRuleEnabled(id, enabled)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.RuleSave).Target("rule", strconv.Itoa(id)).
+		Change(map[string]bool{"enabled": !enabled}, map[string]bool{"enabled": enabled}).Record(repo.DB)
+
+	http.Redirect(w, r, "/admin/rules", http.StatusSeeOther)
+}
+
+// DeleteRule removes a rule
+func (repo *DBRepo) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if !repo.canChangeRule(r, id) {
+		http.Error(w, "Only the rule's owner or an admin can delete it", http.StatusForbidden)
+		return
+	}
+
+	err = repo.DB.DeleteRule(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	repo.auditEvent(r, audit.RuleDelete).Target("rule", strconv.Itoa(id)).Record(repo.DB)
+
+	repo.App.Session.Put(r.Context(), "flash", "Rule deleted")
+	http.Redirect(w, r, "/admin/rules", http.StatusSeeOther)
+}
+
+// canChangeRule reports whether the current user owns rule id or is an admin
+func (repo *DBRepo) canChangeRule(r *http.Request, id int) bool {
+	user, err := repo.DB.GetUserById(repo.App.Session.GetString(r.Context(), "user_id"))
+	if err != nil {
+		return false
+	}
+	if user.AccessLevel >= models.AccessAdmin {
+		return true
+	}
+	rule, err := repo.DB.GetRuleById(id)
+	return err == nil && rule.UserID == user.ID
+}
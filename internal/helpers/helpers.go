@@ -3,14 +3,12 @@ package helpers
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/SeatSnobAri/seatflipsite/internal/config"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
 	"github.com/SeatSnobAri/seatflipsite/internal/models"
 	"github.com/SeatSnobAri/seatflipsite/internal/templates"
 	"github.com/justinas/nosurf"
 	"io"
-
-	"log"
 	"math/rand"
 	"net/http"
 	"runtime/debug"
@@ -59,8 +57,11 @@ func RandomString(n int) string {
 
 // ServerError will display error page for internal server error
 func ServerError(w http.ResponseWriter, r *http.Request, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	_ = log.Output(2, trace)
+	logging.FromContext(r.Context()).Error("server error",
+		"err", err,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"stack", string(debug.Stack()))
 
 	w.WriteHeader(http.StatusInternalServerError)
 	w.Header().Set("Connection", "close")
@@ -95,8 +96,11 @@ type jsonResponse struct {
 
 // ReadJSON tries to read the body of a request and converts it into JSON
 func ReadJSON(w http.ResponseWriter, r *http.Request, data any) error {
-	maxBytes := 1048576 // one megabyte
+	return ReadJSONLimit(w, r, data, 1048576) // one megabyte
+}
 
+// ReadJSONLimit is ReadJSON for bodies that may be larger than a megabyte
+func ReadJSONLimit(w http.ResponseWriter, r *http.Request, data any, maxBytes int) error {
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
 	dec := json.NewDecoder(r.Body)
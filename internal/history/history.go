@@ -0,0 +1,142 @@
+// Package history rebuilds carts from their append-only event log, either as they are now or as
+// they were at any moment in the past.
+package history
+
+import (
+	"encoding/json"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"sort"
+	"time"
+)
+
+// Produced is the data of a produced event
+type Produced struct {
+	Cart models.UflipPayload `json:"cart"`
+	TTL  time.Duration       `json:"ttl"`
+}
+
+// Extended is the data of an extended event
+type Extended struct {
+	TTL time.Duration `json:"ttl"`
+}
+
+// Bought is the data of a bought event
+type Bought struct {
+	Override bool `json:"override,omitempty"`
+}
+
+// Confirmed is the data of a confirmed event
+type Confirmed struct {
+	OrderNumber  string  `json:"order_number"`
+	ChargedTotal float64 `json:"charged_total"`
+	Flagged      bool    `json:"flagged,omitempty"`
+}
+
+// Cart is a cart's state projected from its events
+type Cart struct {
+	Cart models.UflipPayload
+	// Status is the type of the last event other than an extension
+	Status      string
+	ProducedAt  time.Time
+	ExpiresAt   time.Time
+	ExpiredAt   time.Time
+	ClaimedBy   []string
+	BoughtBy    string
+	BoughtAt    time.Time
+	Override    bool
+	OrderNumber string
+	Charged     float64
+	Flagged     bool
+	Events      []models.CartEvent
+}
+
+// LiveAt reports whether the cart was on the dashboard at t: produced by then, not declined, and
+// neither expired nor past its time to live
+func (c Cart) LiveAt(t time.Time) bool {
+	if c.ProducedAt.IsZero() || c.ProducedAt.After(t) || c.Status == models.CartDeclined {
+		return false
+	}
+	if !c.ExpiredAt.IsZero() && !c.ExpiredAt.After(t) {
+		return false
+	}
+	return c.ExpiresAt.IsZero() || c.ExpiresAt.After(t)
+}
+
+// Project folds one cart's events, oldest first, into its state
+func Project(events []models.CartEvent) Cart {
+	var c Cart
+	for _, e := range events {
+		apply(&c, e)
+	}
+	return c
+}
+
+func apply(c *Cart, e models.CartEvent) {
+	c.Events = append(c.Events, e)
+
+	switch e.Type {
+	case models.CartProduced:
+		var d Produced
+		_ = json.Unmarshal(e.Data, &d)
+		c.Cart = d.Cart
+		c.ProducedAt = e.CreatedAt
+		c.ExpiresAt = e.CreatedAt.Add(d.TTL)
+	case models.CartDeclined:
+		c.ExpiresAt = e.CreatedAt
+	case models.CartClaimed:
+		c.ClaimedBy = append(c.ClaimedBy, e.Actor)
+	case models.CartBought:
+		var d Bought
+		_ = json.Unmarshal(e.Data, &d)
+		c.Cart.Buy = true
+		c.BoughtBy = e.Actor
+		c.BoughtAt = e.CreatedAt
+		c.Override = d.Override
+	case models.CartExtended:
+		var d Extended
+		_ = json.Unmarshal(e.Data, &d)
+		c.ExpiresAt = e.CreatedAt.Add(d.TTL)
+	case models.CartExpired:
+		c.ExpiredAt = e.CreatedAt
+	case models.CartConfirmed:
+		var d Confirmed
+		_ = json.Unmarshal(e.Data, &d)
+		c.OrderNumber = d.OrderNumber
+		c.Charged = d.ChargedTotal
+		c.Flagged = d.Flagged
+	}
+
+	// extending a cart keeps it where it was in its life
+	if e.Type != models.CartExtended {
+		c.Status = e.Type
+	}
+}
+
+// Replay rebuilds the dashboard as it was at t from the events of every cart, oldest first. Only
+// events up to t are applied and only carts that were live at t are returned, oldest first.
+func Replay(events []models.CartEvent, t time.Time) []Cart {
+	byCart := make(map[string]*Cart)
+	for _, e := range events {
+		if e.CreatedAt.After(t) {
+			continue
+		}
+		c, ok := byCart[e.CartID]
+		if !ok {
+			c = &Cart{}
+			byCart[e.CartID] = c
+		}
+		apply(c, e)
+	}
+
+	var live []Cart
+	for _, c := range byCart {
+		if c.LiveAt(t) {
+			live = append(live, *c)
+		}
+	}
+
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].ProducedAt.Before(live[j].ProducedAt)
+	})
+	return live
+}
@@ -0,0 +1,246 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/forms"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields are the cart and purchase fields a csv column can be mapped onto
+var Fields = []string{
+	"id",
+	"created_at",
+	"event_date",
+	"event_name",
+	"event_venue",
+	"seat_info",
+	"ticket_info",
+	"ticket_price",
+	"ticket_total",
+	"stock_type",
+	"user_id",
+	"state",
+	"quantity",
+	"cost_basis",
+	"fees",
+	"listing_price",
+	"sale_price",
+}
+
+// money fields have currency symbols and thousands separators stripped before validation
+var moneyFields = []string{"ticket_total", "cost_basis", "fees", "listing_price", "sale_price"}
+
+var dateLayouts = []string{"2006-01-02", time.RFC3339, "2006-01-02 15:04:05", "01/02/2006"}
+
+// ErrNoHeader is returned when the file is empty
+var ErrNoHeader = errors.New("importer: missing header row")
+
+// Mapping maps a field name onto the csv header holding its value
+type Mapping map[string]string
+
+// DefaultMapping expects csv headers to be named after the fields
+func DefaultMapping() Mapping {
+	m := make(Mapping)
+	for _, f := range Fields {
+		m[f] = f
+	}
+	return m
+}
+
+// ParseMapping reads overrides in field=header,field=header form on top of the default mapping
+func ParseMapping(s string) (Mapping, error) {
+	m := DefaultMapping()
+	if strings.TrimSpace(s) == "" {
+		return m, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		field, header, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("importer: mapping %q must be field=header", pair)
+		}
+		if err := m.Set(field, header); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Set maps field onto header
+func (m Mapping) Set(field, header string) error {
+	field = strings.TrimSpace(field)
+	if _, ok := m[field]; !ok {
+		return fmt.Errorf("importer: unknown field %q", field)
+	}
+	m[field] = strings.TrimSpace(header)
+	return nil
+}
+
+// Purchases reads historical purchases from r and stores them as bought carts with inventory rows.
+// Rows whose cart already exists are skipped, so importing the same file twice is harmless. Rows
+// without a user_id are attributed to agent. When dryRun is true nothing is written.
+func Purchases(db repository.DatabaseRepo, r io.Reader, m Mapping, agent string, dryRun bool) (models.ImportResult, error) {
+	result := models.ImportResult{DryRun: dryRun}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return result, ErrNoHeader
+	} else if err != nil {
+		return result, err
+	}
+
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	seen := make(map[string]bool)
+	var purchases []models.ImportedPurchase
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		line, _ := cr.FieldPos(0)
+		result.Rows++
+
+		values := url.Values{}
+		for field, h := range m {
+			if i, ok := columns[strings.ToLower(h)]; ok && i < len(record) {
+				values.Set(field, strings.TrimSpace(record[i]))
+			}
+		}
+		for _, field := range moneyFields {
+			values.Set(field, strings.NewReplacer("$", "", ",", "").Replace(values.Get(field)))
+		}
+		if values.Get("user_id") == "" {
+			values.Set("user_id", agent)
+		}
+
+		form := forms.New(values)
+		form.Required("event_name", "event_date", "ticket_total", "quantity", "user_id")
+		form.IsFloat(moneyFields...)
+		form.IsInt("quantity")
+		form.IsDate("created_at", dateLayouts...)
+		form.IsIn("state", models.InventoryStates...)
+
+		if !form.Valid() {
+			result.Errors = append(result.Errors, models.ImportRowErrors{
+				Line:   line,
+				Errors: form.Errors,
+			})
+			continue
+		}
+
+		cart, item := rowToPurchase(form)
+
+		if seen[cart.ID] {
+			result.Skipped++
+			continue
+		}
+		seen[cart.ID] = true
+
+		if dryRun {
+			exists, err := db.CartExists(cart.ID)
+			if err != nil {
+				return result, err
+			}
+			if exists {
+				result.Skipped++
+			} else {
+				result.Imported++
+			}
+			continue
+		}
+
+		purchases = append(purchases, models.ImportedPurchase{Line: line, Cart: cart, Item: item})
+	}
+	if dryRun || len(purchases) == 0 {
+		return result, nil
+	}
+
+	// the rows are stored together, so a failure leaves nothing half imported
+	imported, err := db.ImportPurchases(purchases)
+	if err != nil {
+		return result, err
+	}
+	result.Imported = imported
+	result.Skipped += len(purchases) - imported
+
+	return result, nil
+}
+
+// rowToPurchase builds the cart and inventory item for a validated row
+func rowToPurchase(form *forms.Form) (models.Cart, models.InventoryItem) {
+	cart := models.Cart{
+		ID:          form.Get("id"),
+		EventDate:   form.Get("event_date"),
+		EventName:   form.Get("event_name"),
+		EventVenue:  form.Get("event_venue"),
+		SeatInfo:    form.Get("seat_info"),
+		TicketInfo:  form.Get("ticket_info"),
+		TicketPrice: form.Get("ticket_price"),
+		StockType:   form.Get("stock_type"),
+		UserID:      form.Get("user_id"),
+		Bought:      true,
+		CreatedAt:   time.Now(),
+	}
+	cart.TicketTotal, _ = strconv.ParseFloat(form.Get("ticket_total"), 64)
+	cart.Quantity, _ = strconv.Atoi(form.Get("quantity"))
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, form.Get("created_at")); err == nil {
+			cart.CreatedAt = t
+			break
+		}
+	}
+
+	// without an id, a row is identified by its contents so re-imports are skipped
+	if cart.ID == "" {
+		cart.ID = rowKey(form)
+	}
+
+	item := models.InventoryItem{
+		CartID:    cart.ID,
+		State:     form.Get("state"),
+		Quantity:  cart.Quantity,
+		CostBasis: cart.TicketTotal,
+	}
+	if item.State == "" {
+		item.State = models.InventoryPurchased
+	}
+	if form.Has("cost_basis") {
+		item.CostBasis, _ = strconv.ParseFloat(form.Get("cost_basis"), 64)
+	}
+	item.Fees, _ = strconv.ParseFloat(form.Get("fees"), 64)
+	item.ListingPrice, _ = strconv.ParseFloat(form.Get("listing_price"), 64)
+	item.SalePrice, _ = strconv.ParseFloat(form.Get("sale_price"), 64)
+
+	return cart, item
+}
+
+// rowKey returns a stable cart id derived from the row's values
+func rowKey(form *forms.Form) string {
+	h := sha256.New()
+	for _, field := range Fields {
+		fmt.Fprintf(h, "%s=%s\n", field, form.Get(field))
+	}
+	return "import-" + hex.EncodeToString(h.Sum(nil))[:24]
+}
@@ -0,0 +1,289 @@
+// Package jobs runs background work from a queue kept in Postgres. A bounded pool of workers
+// claims due jobs, retries the ones that fail with backoff, and queues jobs on cron schedules.
+// Every instance of the server can run a pool against the same queue: jobs are claimed with row
+// locks and each scheduled run is queued by exactly one instance.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository"
+	"github.com/robfig/cron/v3"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// pollInterval is how often idle workers look for due jobs when nobody has notified them
+	pollInterval = 2 * time.Second
+	// scheduleInterval is how often schedules are checked; cron specs have minute resolution
+	scheduleInterval = 15 * time.Second
+	// jobTimeout is how long one attempt at a job may run
+	jobTimeout = 10 * time.Minute
+	// staleAfter is how long a job can be running before it's assumed its worker died
+	staleAfter = jobTimeout + 5*time.Minute
+	// firstRetry is the wait after the first failure; it doubles with every failure after that
+	firstRetry = 10 * time.Second
+	// maxRetry caps the wait between attempts
+	maxRetry = 30 * time.Minute
+)
+
+// Handler does the work of one job. Whatever it returns is stored as the job's result.
+type Handler func(ctx context.Context, j models.Job) (any, error)
+
+type schedule struct {
+	name    string
+	kind    string
+	spec    cron.Schedule
+	payload json.RawMessage
+}
+
+// Pool runs jobs with a fixed number of workers
+type Pool struct {
+	db      repository.DatabaseRepo
+	workers int
+	perKind int
+	wake    chan struct{}
+
+	mu        sync.Mutex
+	handlers  map[string]Handler
+	running   map[string]int
+	schedules []schedule
+}
+
+// New returns a pool of workers goroutines that runs at most perKind jobs of the same kind at once
+func New(db repository.DatabaseRepo, workers, perKind int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if perKind < 1 || perKind > workers {
+		perKind = workers
+	}
+	return &Pool{
+		db:       db,
+		workers:  workers,
+		perKind:  perKind,
+		wake:     make(chan struct{}, 1),
+		handlers: make(map[string]Handler),
+		running:  make(map[string]int),
+	}
+}
+
+// Register sets the handler for jobs of kind. The pool only claims kinds it has a handler for.
+func (p *Pool) Register(kind string, h Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[kind] = h
+}
+
+// Schedule queues a job of kind with payload whenever the cron spec comes due, e.g. "*/5 * * * *".
+// Name identifies the schedule across restarts and instances.
+func (p *Pool) Schedule(name, spec, kind string, payload any) error {
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("jobs: schedule %s: %w", name, err)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.schedules = append(p.schedules, schedule{name: name, kind: kind, spec: s, payload: b})
+	return nil
+}
+
+// Enqueue queues a job of kind to run as soon as a worker is free and returns its id. UserID is
+// who asked for it, if anyone.
+func (p *Pool) Enqueue(kind string, payload any, userID string) (int64, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := p.db.EnqueueJob(models.Job{Kind: kind, Payload: b, UserID: userID})
+	if err != nil {
+		return 0, err
+	}
+
+	p.Notify()
+	return id, nil
+}
+
+// Notify wakes an idle worker because a job was queued. It never blocks.
+func (p *Pool) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run starts the workers and the scheduler, and blocks until ctx is done and every job in progress
+// has returned
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.schedule(ctx)
+	}()
+
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// work runs jobs until ctx is done, waiting for a notification or the poll interval when idle
+func (p *Pool) work(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		j, ok, err := p.claim()
+		if err != nil {
+			slog.Error("jobs: can't claim a job", "err", err)
+		}
+		if ok {
+			p.run(ctx, j)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// claim takes the next due job of a kind that has a handler and a free slot. The lock is held
+// across the claim so two workers can't both take the last slot of a kind.
+func (p *Pool) claim() (models.Job, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var kinds []string
+	for kind := range p.handlers {
+		if p.running[kind] < p.perKind {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		return models.Job{}, false, nil
+	}
+
+	j, ok, err := p.db.ClaimJob(kinds, staleAfter)
+	if ok {
+		p.running[j.Kind]++
+	}
+	return j, ok, err
+}
+
+// run does one attempt at j and records the outcome
+func (p *Pool) run(ctx context.Context, j models.Job) {
+	p.mu.Lock()
+	h := p.handlers[j.Kind]
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.running[j.Kind]--
+		p.mu.Unlock()
+	}()
+
+	// handlers log through the context, so their lines carry the job too
+	logger := slog.With("job_id", j.ID, "kind", j.Kind, "attempt", j.Attempts)
+	result, err := call(logging.NewContext(ctx, logger), h, j)
+	if err == nil {
+		var b []byte
+		if result != nil {
+			b, err = json.Marshal(result)
+		}
+		if err == nil {
+			if err = p.db.CompleteJob(j.ID, b); err != nil {
+				logger.Error("jobs: can't complete job", "err", err)
+			}
+			return
+		}
+	}
+
+	if j.Attempts >= j.MaxAttempts {
+		logger.Error("jobs: giving up on job", "err", err)
+		err = p.db.FailJob(j.ID, err.Error())
+	} else {
+		logger.Warn("jobs: attempt failed", "err", err)
+		err = p.db.RetryJob(j.ID, err.Error(), time.Now().Add(Backoff(j.Attempts)))
+	}
+	if err != nil {
+		logger.Error("jobs: can't record failure", "err", err)
+	}
+}
+
+// call runs h with a timeout, turning a panic into an error so one bad job can't stop a worker
+func call(ctx context.Context, h Handler, j models.Job) (result any, err error) {
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return h(ctx, j)
+}
+
+// schedule queues scheduled jobs as they come due until ctx is done
+func (p *Pool) schedule(ctx context.Context) {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	for {
+		p.mu.Lock()
+		schedules := p.schedules
+		p.mu.Unlock()
+
+		queued := false
+		for _, s := range schedules {
+			ok, err := p.db.ScheduleJob(s.name, s.spec.Next(time.Now()), models.Job{Kind: s.kind, Payload: s.payload})
+			if err != nil {
+				slog.Error("jobs: can't queue scheduled job", "schedule", s.name, "err", err)
+			}
+			queued = queued || ok
+		}
+		if queued {
+			p.Notify()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Backoff is the wait before the next attempt after attempts failures: firstRetry after the first,
+// doubling after every one that follows, up to maxRetry
+func Backoff(attempts int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	if wait > maxRetry {
+		wait = maxRetry
+	}
+	return wait
+}
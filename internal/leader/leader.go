@@ -0,0 +1,163 @@
+// Package leader elects one instance to run work that must not run on every node at once, such as
+// watching for cart expiries. Leadership is a Postgres session advisory lock held on a dedicated
+// connection: it is released the moment the leader shuts down, or when Postgres notices its session
+// is gone, and the other instances try for it every retryInterval.
+//
+// After a crash or network partition the lock is held until Postgres drops the dead session, which
+// with the server's default TCP keepalives can take hours. So the lock connection turns on short
+// keepalives and, on Postgres 14 and later, an idle session timeout well above checkInterval: a
+// leader that can't be reached loses the lock within about fifteen seconds. The leader itself
+// steps down as soon as it can't ping its connection.
+//
+// Work that is already safe on every node doesn't need a leader. The job pool's schedules are
+// claimed in Postgres so each run is queued once, jobs and outbox messages are taken with
+// skip locked, sessions and remember tokens live in Postgres, CSRF and OAuth state are cookies,
+// and the realtime hub is Pusher. Attachments are kept on local disk, so every instance needs the
+// same storage path on a shared volume.
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// retryInterval is how often a follower tries to become leader
+	retryInterval = 2 * time.Second
+	// checkInterval is how often the leader checks it still holds its connection, and so the lock
+	checkInterval = 2 * time.Second
+	// checkTimeout is how long that check may take before leadership is given up
+	checkTimeout = time.Second
+)
+
+// sessionSettings have Postgres drop the lock connection's session, and so the lock, soon after
+// the leader stops answering
+var sessionSettings = []string{
+	`set tcp_keepalives_idle = 5`,
+	`set tcp_keepalives_interval = 2`,
+	`set tcp_keepalives_count = 3`,
+}
+
+// idleSessionTimeout is set too where Postgres supports it, for a leader whose host still answers
+// keepalives but whose process has stopped
+const idleSessionTimeout = `set idle_session_timeout = '10s'`
+
+// Elector campaigns for one named role
+type Elector struct {
+	db      *sql.DB
+	name    string
+	key     int64
+	leading atomic.Bool
+	logger  *slog.Logger
+}
+
+// New returns an elector for the role name; every instance must use the same name
+func New(db *sql.DB, name string) *Elector {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return &Elector{db: db, name: name, key: int64(h.Sum64()), logger: slog.With("role", name)}
+}
+
+// Leading reports whether this instance is leader now
+func (e *Elector) Leading() bool {
+	return e.leading.Load()
+}
+
+// Run campaigns until ctx is done, calling lead whenever this instance becomes leader. The context
+// lead is given is cancelled as soon as leadership is lost, and Run waits for lead to return before
+// it campaigns again.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) {
+	for {
+		won, err := e.term(ctx, lead)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			e.logger.Error("leader: election failed", "err", err)
+		}
+		if won {
+			e.logger.Warn("leader: lost leadership")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// term tries for the lock once and, if it gets it, leads until the connection fails or ctx is done.
+// It reports whether it became leader.
+func (e *Elector) term(ctx context.Context, lead func(ctx context.Context)) (bool, error) {
+	conn, err := e.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	for _, stmt := range sessionSettings {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return false, err
+		}
+	}
+	if _, err = conn.ExecContext(ctx, idleSessionTimeout); err != nil {
+		e.logger.Debug("leader: idle session timeout not supported", "err", err)
+	}
+	defer func() {
+		// the connection goes back to the pool, where nothing else wants these settings
+		_, _ = conn.ExecContext(context.Background(), `reset all`)
+	}()
+
+	var won bool
+	err = conn.QueryRowContext(ctx, `select pg_try_advisory_lock($1)`, e.key).Scan(&won)
+	if err != nil || !won {
+		return false, err
+	}
+	defer func() {
+		// a connection that is still alive goes back to the pool, so the lock has to be let go of
+		_, _ = conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, e.key)
+	}()
+	e.logger.Info("leader: this instance is leader")
+	e.leading.Store(true)
+	defer e.leading.Store(false)
+
+	leadCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case <-done:
+			return true, nil
+		case <-ticker.C:
+		}
+
+		if err = e.check(ctx, conn); err != nil {
+			return true, err
+		}
+	}
+}
+
+// check pings the connection holding the lock
+func (e *Elector) check(ctx context.Context, conn *sql.Conn) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	return conn.PingContext(ctx)
+}
@@ -0,0 +1,143 @@
+package leader
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDatabaseEnv names the variable holding the url of a Postgres server to elect leaders on.
+// Without it the tests are skipped.
+const testDatabaseEnv = "SEATFLIP_TEST_DATABASE_URL"
+
+// openDB returns a connection pool of its own, as each instance has
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := stdlib.OpenDB(*config)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// role returns a role name no other test or instance on the server campaigns for
+func role(t *testing.T) string {
+	return fmt.Sprintf("%s %d", t.Name(), time.Now().UnixNano())
+}
+
+// campaign runs e until the test ends or the returned cancel is called
+func campaign(t *testing.T, e *Elector) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		e.Run(ctx, func(ctx context.Context) { <-ctx.Done() })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return cancel
+}
+
+// eventually waits up to within for cond to hold
+func eventually(within time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(within)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return cond()
+}
+
+// leaders returns the electors that are leading
+func leaders(electors ...*Elector) []*Elector {
+	var leading []*Elector
+	for _, e := range electors {
+		if e.Leading() {
+			leading = append(leading, e)
+		}
+	}
+	return leading
+}
+
+func TestOneLeader(t *testing.T) {
+	name := role(t)
+	a, b := New(openDB(t), name), New(openDB(t), name)
+	campaign(t, a)
+	campaign(t, b)
+
+	if !eventually(retryInterval+time.Second, func() bool { return len(leaders(a, b)) == 1 }) {
+		t.Fatalf("%d leaders, want 1", len(leaders(a, b)))
+	}
+
+	// through a few rounds of retries and checks
+	for end := time.Now().Add(2 * retryInterval); time.Now().Before(end); time.Sleep(100 * time.Millisecond) {
+		if n := len(leaders(a, b)); n != 1 {
+			t.Fatalf("%d leaders, want 1", n)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	name := role(t)
+	a := New(openDB(t), name)
+	stopA := campaign(t, a)
+	if !eventually(time.Second, a.Leading) {
+		t.Fatal("first elector didn't become leader")
+	}
+
+	b := New(openDB(t), name)
+	campaign(t, b)
+	time.Sleep(retryInterval + 500*time.Millisecond)
+	if b.Leading() {
+		t.Fatal("second elector leads while the first still does")
+	}
+
+	stopA()
+	if !eventually(retryInterval+time.Second, b.Leading) {
+		t.Fatalf("follower didn't take over within %s", retryInterval)
+	}
+	if a.Leading() {
+		t.Error("stopped elector still reports leading")
+	}
+}
+
+func TestDeadConnection(t *testing.T) {
+	db := openDB(t)
+	e := New(db, role(t))
+	campaign(t, e)
+	if !eventually(time.Second, e.Leading) {
+		t.Fatal("elector didn't become leader")
+	}
+
+	// kill the session holding the lock, as Postgres does once it notices a partitioned leader
+	var terminated bool
+	err := openDB(t).QueryRow(`select pg_terminate_backend(pid) from pg_locks
+		where locktype = 'advisory' and granted and classid = $1::bigint::oid and objid = $2::bigint::oid`,
+		int64(uint64(e.key)>>32), int64(uint32(e.key))).Scan(&terminated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !terminated {
+		t.Fatal("can't terminate the lock's session")
+	}
+
+	if !eventually(checkInterval+checkTimeout+time.Second, func() bool { return !e.Leading() }) {
+		t.Fatal("elector still reports leading on a dead connection")
+	}
+}
@@ -0,0 +1,82 @@
+// Package livecarts holds produced carts while buyers decide on them. A cart is live until its hold
+// runs out, and every expiry is reported so the dashboards can drop it. Redis, with its key expiry
+// and keyspace notifications, is the usual backend; Postgres can stand in for teams that don't run
+// Redis.
+package livecarts
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// backends a store can be opened with
+const (
+	BackendRedis    = "redis"
+	BackendPostgres = "postgres"
+)
+
+// ErrNotFound the cart is not live error
+var ErrNotFound = errors.New("livecarts: cart is not live")
+
+const (
+	// firstRewatch is the wait after watching first fails; it doubles with every failure after
+	// that, up to maxRewatch
+	firstRewatch = time.Second
+	maxRewatch   = 30 * time.Second
+)
+
+// Store keeps live carts, as the json of their payload, by cart id
+type Store interface {
+	// Put makes a cart live for ttl, replacing anything already held under id
+	Put(ctx context.Context, id string, value []byte, ttl time.Duration) error
+	// Get returns a live cart, or ErrNotFound
+	Get(ctx context.Context, id string) ([]byte, error)
+	// Update replaces a live cart without changing when it expires; a cart that isn't live is left alone
+	Update(ctx context.Context, id string, value []byte) error
+	// All returns every live cart
+	All(ctx context.Context) ([][]byte, error)
+	// Exists reports whether a cart is live
+	Exists(ctx context.Context, id string) (bool, error)
+	// Extend makes a live cart expire ttl from now, reporting false if it isn't live
+	Extend(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// Expire ends a cart's hold now, reporting false if it isn't live. The expiry is reported to
+	// watchers like any other.
+	Expire(ctx context.Context, id string) (bool, error)
+	// Watch calls expired with the id of each cart as its hold runs out, until ctx is done. When the
+	// connection fails it reconnects with backoff, calling watching every time it is ready again;
+	// expiries that happened while it wasn't watching are not reported and have to be swept for.
+	Watch(ctx context.Context, watching func(ctx context.Context), expired func(id string))
+	// Ping checks the backend can be reached
+	Ping(ctx context.Context) error
+	// Close releases the store's connections
+	Close() error
+}
+
+// watch runs once until it fails, resubscribing with backoff, until ctx is done. once reports
+// whether it got as far as watching before it failed.
+func watch(ctx context.Context, name string, once func(ctx context.Context) (bool, error)) {
+	wait := firstRewatch
+
+	for {
+		watching, err := once(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if watching {
+			wait = firstRewatch
+		}
+		slog.Warn(name+": watching again", "err", err, "wait", wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > maxRewatch {
+			wait = maxRewatch
+		}
+	}
+}
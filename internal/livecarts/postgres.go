@@ -0,0 +1,108 @@
+package livecarts
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository"
+	"log/slog"
+	"time"
+)
+
+// sweepInterval is how often the Postgres store looks for holds that have run out
+const sweepInterval = time.Second
+
+// Postgres keeps live carts in the carts.live table with the time each expires. Watch sweeps for
+// expired rows, announces each one it deletes with NOTIFY and LISTENs for the announcements, so
+// the watcher hears of each expiry just as it would from redis. Only the elected leader watches;
+// the other instances learn of expiries from the Pusher broadcasts it sends.
+type Postgres struct {
+	db repository.DatabaseRepo
+}
+
+// NewPostgres returns a store on db
+func NewPostgres(db repository.DatabaseRepo) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Put upserts the row
+func (s *Postgres) Put(ctx context.Context, id string, value []byte, ttl time.Duration) error {
+	return s.db.PutLiveCart(id, value, time.Now().Add(ttl))
+}
+
+// Get returns the row's value if it hasn't expired
+func (s *Postgres) Get(ctx context.Context, id string) ([]byte, error) {
+	b, ok, err := s.db.GetLiveCart(id)
+	if err == nil && !ok {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Update replaces the row's value
+func (s *Postgres) Update(ctx context.Context, id string, value []byte) error {
+	return s.db.UpdateLiveCart(id, value)
+}
+
+// All returns every row that hasn't expired
+func (s *Postgres) All(ctx context.Context) ([][]byte, error) {
+	return s.db.LiveCarts()
+}
+
+// Exists reports whether the row exists and hasn't expired
+func (s *Postgres) Exists(ctx context.Context, id string) (bool, error) {
+	_, ok, err := s.db.GetLiveCart(id)
+	return ok, err
+}
+
+// Extend moves the row's expiry to ttl from now
+func (s *Postgres) Extend(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return s.db.ExpireLiveCartAt(id, time.Now().Add(ttl))
+}
+
+// Expire moves the row's expiry to now, so the next sweep reports it
+func (s *Postgres) Expire(ctx context.Context, id string) (bool, error) {
+	return s.db.ExpireLiveCartAt(id, time.Now())
+}
+
+// Watch listens for expiry notifications while sweeping for expired rows every sweepInterval
+func (s *Postgres) Watch(ctx context.Context, watching func(ctx context.Context), expired func(id string)) {
+	go s.sweep(ctx)
+
+	watch(ctx, "postgres listener", func(ctx context.Context) (bool, error) {
+		listening := false
+		err := s.db.ListenLiveCartExpiries(ctx, func() {
+			listening = true
+			slog.Info("postgres listener: listening for cart expiries")
+			watching(ctx)
+		}, expired)
+		return listening, err
+	})
+}
+
+// sweep deletes expired rows and notifies watchers of them until ctx is done
+func (s *Postgres) sweep(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := s.db.SweepLiveCarts(); err != nil {
+			slog.Error("live cart sweep failed", "err", err)
+		}
+	}
+}
+
+// Ping checks the database can be reached
+func (s *Postgres) Ping(ctx context.Context) error {
+	_, _, err := s.db.GetLiveCart("")
+	return err
+}
+
+// Close does nothing; the database pool belongs to the application
+func (s *Postgres) Close() error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package livecarts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log/slog"
+	"time"
+)
+
+// Redis keeps each live cart under its id as a key with a ttl, and learns of expiries from
+// keyspace notifications
+type Redis struct {
+	client *redis.Client
+	db     int
+}
+
+// NewRedis returns a store on database db of client
+func NewRedis(client *redis.Client, db int) *Redis {
+	return &Redis{client: client, db: db}
+}
+
+// ConfigureKeyspaceEvents turns on keyspace notifications, which are off by default
+func (s *Redis) ConfigureKeyspaceEvents(ctx context.Context) error {
+	return s.client.ConfigSet(ctx, "notify-keyspace-events", "KEA").Err()
+}
+
+// Put sets the key with ttl
+func (s *Redis) Put(ctx context.Context, id string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, id, value, ttl).Err()
+}
+
+// Get returns the key's value
+func (s *Redis) Get(ctx context.Context, id string) ([]byte, error) {
+	b, err := s.client.Get(ctx, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+// Update sets the key if it exists, keeping its ttl
+func (s *Redis) Update(ctx context.Context, id string, value []byte) error {
+	return s.client.SetArgs(ctx, id, value, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+}
+
+// All scans every key in the database
+func (s *Redis) All(ctx context.Context) ([][]byte, error) {
+	var values [][]byte
+
+	iter := s.client.Scan(ctx, 0, "*", 10).Iterator()
+	for iter.Next(ctx) {
+		b, err := s.client.Get(ctx, iter.Val()).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// expired since the scan found it
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, b)
+	}
+
+	return values, iter.Err()
+}
+
+// Exists reports whether the key exists
+func (s *Redis) Exists(ctx context.Context, id string) (bool, error) {
+	n, err := s.client.Exists(ctx, id).Result()
+	return n > 0, err
+}
+
+// Extend resets the key's ttl
+func (s *Redis) Extend(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	return s.client.Expire(ctx, id, ttl).Result()
+}
+
+// Expire lets the key run out in a millisecond, so redis publishes the expiry as usual
+func (s *Redis) Expire(ctx context.Context, id string) (bool, error) {
+	return s.client.PExpire(ctx, id, time.Millisecond).Result()
+}
+
+// Watch subscribes to the expired keyevent channel of the store's database
+func (s *Redis) Watch(ctx context.Context, watching func(ctx context.Context), expired func(id string)) {
+	channel := fmt.Sprintf("__keyevent@%d__:expired", s.db)
+
+	watch(ctx, "redis listener", func(ctx context.Context) (bool, error) {
+		pubsub := s.client.PSubscribe(ctx, channel)
+		defer pubsub.Close()
+
+		// wait for the subscription to be confirmed, so an expiry can't fall between it and watching
+		if _, err := pubsub.Receive(ctx); err != nil {
+			return false, err
+		}
+		slog.Info("redis listener: subscribed", "channel", channel)
+		watching(ctx)
+
+		// closing the subscription is what interrupts a receive that is waiting for a message
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				pubsub.Close()
+			case <-stop:
+			}
+		}()
+
+		for {
+			message, err := pubsub.ReceiveMessage(ctx)
+			if err != nil {
+				return true, err
+			}
+			expired(message.Payload)
+		}
+	})
+}
+
+// Ping pings redis
+func (s *Redis) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+// Close closes the client
+func (s *Redis) Close() error {
+	return s.client.Close()
+}
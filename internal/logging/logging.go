@@ -0,0 +1,104 @@
+// Package logging sets up the application's structured logger. Output is JSON in production and
+// text in development, and the values of sensitive attributes, such as emails, passwords and
+// tokens, are replaced before they are written. Each request carries its own logger in its
+// context, tagged with the request's id.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// RequestIDHeader carries the request id, from a proxy in front of us or back to the client
+const RequestIDHeader = "X-Request-ID"
+
+// Redacted replaces the value of a sensitive attribute
+const Redacted = "[redacted]"
+
+// sensitive are the attribute keys whose values are never written. A key also matches when it
+// ends in one of them, such as user_email or client_secret.
+var sensitive = []string{"email", "password", "secret", "token", "authorization", "cookie", "dsn"}
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+// New returns a logger writing to w at level and above, as JSON if json is set
+func New(w io.Writer, json bool, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	if json {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Sensitive reports whether the value of the attribute key must not be logged
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitive {
+		if key == s || strings.HasSuffix(key, "_"+s) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if Sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// NewContext returns a copy of ctx carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// WithRequestID returns a copy of ctx carrying the request id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the id of the request ctx belongs to, or an empty string
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// NewRequestID returns a random request id
+func NewRequestID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether an id sent by a client is safe to log and send back
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+// Package metrics defines the Prometheus metrics the application exports on /metrics, with hooks
+// for the clients it talks to: a go-redis hook, a pgx logger that times every query, and an http
+// transport for the Pusher client.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const namespace = "seatflip"
+
+// cart lifecycle events
+const (
+	CartProduced = "produced"
+	CartBought   = "bought"
+	CartExpired  = "expired"
+)
+
+// stockTypes are the stock types carts are counted under; the extension sends whatever the page
+// says, so anything else is counted as otherStockType to keep the number of series down
+var stockTypes = map[string]bool{
+	"standard":        true,
+	"resale":          true,
+	"verified resale": true,
+	"platinum":        true,
+	"vip":             true,
+}
+
+const otherStockType = "other"
+
+// dependencies
+const (
+	Postgres = "postgres"
+	Redis    = "redis"
+	Pusher   = "pusher"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to answer http requests, by route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	brokerActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broker_actions_total",
+		Help:      "Broker actions sent by the extension, by outcome.",
+	}, []string{"action", "outcome"})
+
+	carts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "carts_total",
+		Help:      "Carts produced, bought and expired, by the agent's team and the stock type.",
+	}, []string{"event", "team", "stock_type"})
+
+	decisionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cart_decision_seconds",
+		Help:      "Time from a cart being produced to it being bought or expiring.",
+		Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 900, 1800, 3600},
+	}, []string{"decision"})
+
+	dependencyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dependency_duration_seconds",
+		Help:      "Time taken by calls to Postgres, Redis and Pusher.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"dependency", "operation"})
+
+	dependencyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dependency_errors_total",
+		Help:      "Calls to Postgres, Redis and Pusher that failed.",
+	}, []string{"dependency", "operation"})
+)
+
+// ObserveRequest records how long a request to route took
+func ObserveRequest(method, route string, status int, took time.Duration) {
+	requestDuration.WithLabelValues(method, route, statusClass(status)).Observe(took.Seconds())
+}
+
+// BrokerAction counts a broker action, with its outcome taken from the response status
+func BrokerAction(action string, status int) {
+	outcome := "ok"
+	switch {
+	case status >= 500:
+		outcome = "error"
+	case status >= 400:
+		outcome = "rejected"
+	}
+	brokerActions.WithLabelValues(action, outcome).Inc()
+}
+
+// Cart counts a cart reaching a step of its lifecycle
+func Cart(event, team, stockType string) {
+	carts.WithLabelValues(event, team, stockTypeLabel(stockType)).Inc()
+}
+
+// stockTypeLabel returns the label a cart's stock type is counted under
+func stockTypeLabel(stockType string) string {
+	s := strings.ToLower(strings.Join(strings.Fields(stockType), " "))
+	if stockTypes[s] {
+		return s
+	}
+	return otherStockType
+}
+
+// CartDecided records a cart being bought or expiring, waited after it was produced
+func CartDecided(decision, team, stockType string, waited time.Duration) {
+	Cart(decision, team, stockType)
+	decisionDuration.WithLabelValues(decision).Observe(waited.Seconds())
+}
+
+// ObserveDependency records one call to a dependency
+func ObserveDependency(dependency, operation string, took time.Duration, err error) {
+	dependencyDuration.WithLabelValues(dependency, operation).Observe(took.Seconds())
+	if err != nil {
+		dependencyErrors.WithLabelValues(dependency, operation).Inc()
+	}
+}
+
+// statusClass groups status codes as 2xx, 3xx and so on, to keep the number of series down
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// RedisHook times every redis command
+type RedisHook struct{}
+
+// DialHook passes dials through
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := next(ctx, network, addr)
+		ObserveDependency(Redis, "dial", time.Since(start), err)
+		return conn, err
+	}
+}
+
+// ProcessHook times a command; a missing key is not an error
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		ObserveDependency(Redis, strings.ToLower(cmd.Name()), time.Since(start), redisErr(err))
+		return err
+	}
+}
+
+// ProcessPipelineHook times a pipeline as one call
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		ObserveDependency(Redis, "pipeline", time.Since(start), redisErr(err))
+		return err
+	}
+}
+
+func redisErr(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// PgxLogger times every query and exec, from the log entries pgx writes when they finish. Set it as
+// the connection's Logger with a level of pgx.LogLevelInfo.
+type PgxLogger struct{}
+
+// Log records the entries that carry a duration
+func (PgxLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	took, ok := data["time"].(time.Duration)
+	if !ok {
+		return
+	}
+	err, _ := data["err"].(error)
+	ObserveDependency(Postgres, strings.ToLower(msg), took, err)
+}
+
+// Transport times the requests made through next, counting errors and error responses
+func Transport(dependency string, next http.RoundTripper) http.RoundTripper {
+	return roundTripper{dependency: dependency, next: next}
+}
+
+type roundTripper struct {
+	dependency string
+	next       http.RoundTripper
+}
+
+func (t roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(r)
+
+	failed := err
+	if err == nil && resp.StatusCode >= 400 {
+		failed = errors.New(resp.Status)
+	}
+	ObserveDependency(t.dependency, strings.ToLower(r.Method), time.Since(start), failed)
+	return resp, err
+}
@@ -0,0 +1,325 @@
+// Package migrate applies the SQL migrations embedded in the binary and records which versions a
+// database has, in the schema_migrations table. Each migration runs in its own transaction, and
+// runs are serialized with an advisory lock so two instances starting at once can't race.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// VersionLayout is the timestamp format of migration versions
+const VersionLayout = "20060102150405"
+
+// lockID is the advisory lock held while migrations run
+const lockID = 7301948465
+
+// ErrSchemaBehind the database is missing migrations the code needs error
+var ErrSchemaBehind = errors.New("migrate: database schema is behind")
+
+var fileRegex = regexp.MustCompile(`^(\d{14})_([a-z0-9_]+)\.(up|down)\.sql$`)
+var nameRegex = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Migration is one version of the schema
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether the database has it
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Runner applies migrations to a database
+type Runner struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// Load reads the migrations in fsys, oldest first. Every version needs an up and a down file, and
+// files that don't follow the naming scheme are an error so nothing is silently skipped.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) == ".go" {
+			continue
+		}
+		m := fileRegex.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migrate: %s is not named <version>_<name>.up.sql or .down.sql", e.Name())
+		}
+
+		version, _ := strconv.ParseInt(m[1], 10, 64)
+		b, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migrate: version %d has two names, %s and %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migrate: %d_%s has no up migration", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// New returns a runner for the migrations in fsys
+func New(db *sql.DB, fsys fs.FS) (*Runner, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Runner{db: db, migrations: migrations}, nil
+}
+
+// Up applies every pending migration and returns the ones it applied
+func (r *Runner) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range r.migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err = run(ctx, conn, m.Up, `insert into schema_migrations (version, name) values ($1, $2)`,
+				m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migrate: %d_%s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Down reverts the most recently applied steps migrations and returns the ones it reverted
+func (r *Runner) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(r.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := r.migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			err = run(ctx, conn, m.Down, `delete from schema_migrations where version = $1`, m.Version)
+			if err != nil {
+				return fmt.Errorf("migrate: %d_%s: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Status lists every migration and whether it has been applied
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+
+	err := r.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range r.migrations {
+			at, ok := applied[m.Version]
+			statuses = append(statuses, Status{Migration: m, Applied: ok, AppliedAt: at})
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Check returns ErrSchemaBehind when the database is missing any migration
+func (r *Runner) Check(ctx context.Context) error {
+	statuses, err := r.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, fmt.Sprintf("%d_%s", s.Version, s.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migrations, the oldest is %s", ErrSchemaBehind, len(pending), pending[0])
+	}
+	return nil
+}
+
+// locked runs fn on one connection while holding the migration lock, after making sure the
+// schema_migrations table exists
+func (r *Runner) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockID)
+
+	if err = r.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// ensureTable creates schema_migrations. A database that was migrated with soda has its versions
+// in schema_migration, and those are carried over the first time so they aren't applied twice.
+func (r *Runner) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	var exists bool
+	err := conn.QueryRowContext(ctx, `select to_regclass('public.schema_migrations') is not null`).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `create table schema_migrations (
+			version    bigint primary key,
+			name       text not null,
+			applied_at timestamptz not null default now())`)
+	if err != nil {
+		return err
+	}
+
+	var soda bool
+	err = tx.QueryRowContext(ctx, `select to_regclass('public.schema_migration') is not null`).Scan(&soda)
+	if err != nil {
+		return err
+	}
+	if soda {
+		for _, m := range r.migrations {
+			_, err = tx.ExecContext(ctx, `insert into schema_migrations (version, name)
+					select $1, $2 where exists (select 1 from schema_migration where version = $3)`,
+				m.Version, m.Name, strconv.FormatInt(m.Version, 10))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// appliedVersions returns when each applied version was applied
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// run executes a migration script and records it in one transaction
+func run(ctx context.Context, conn *sql.Conn, script, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if script != "" {
+		if _, err = tx.ExecContext(ctx, script); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Create writes empty up and down files for a new migration to dir and returns their paths
+func Create(dir, name string, now time.Time) (string, string, error) {
+	if !nameRegex.MatchString(name) {
+		return "", "", fmt.Errorf("migrate: name %q must be lower case letters, digits and underscores", name)
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%s_%s", now.UTC().Format(VersionLayout), name))
+	up, down := base+".up.sql", base+".down.sql"
+
+	for _, path := range []string{up, down} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		if err = f.Close(); err != nil {
+			return "", "", err
+		}
+	}
+	return up, down, nil
+}
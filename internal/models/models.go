@@ -1,7 +1,13 @@
 package models
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -14,6 +20,33 @@ var (
 	ErrDuplicateEmail = errors.New("models: duplicate email")
 	// ErrInactiveAccount inactive account error
 	ErrInactiveAccount = errors.New("models: Inactive Account")
+	// ErrInvalidTransition inventory state change not allowed error
+	ErrInvalidTransition = errors.New("models: invalid inventory state transition")
+	// ErrAlreadyBought cart has already been bought error
+	ErrAlreadyBought = errors.New("models: cart has already been bought")
+	// ErrNotBought cart has not been bought error
+	ErrNotBought = errors.New("models: cart has not been bought")
+	// ErrOrderNotOpen snipe order has already been closed error
+	ErrOrderNotOpen = errors.New("models: snipe order is not open")
+	// ErrNoQuantity a cart was sent without its number of tickets
+	ErrNoQuantity = errors.New("models: cart quantity must be at least 1")
+)
+
+// user access levels
+const (
+	AccessAgent = 1
+	AccessBuyer = 2
+	AccessAdmin = 3
+)
+
+// AccessLevels maps the role names used by operators to access levels
+var AccessLevels = map[string]int{"agent": AccessAgent, "buyer": AccessBuyer, "admin": AccessAdmin}
+
+// user statuses
+const (
+	UserPending  = "pending"
+	UserActive   = "active"
+	UserDisabled = "disabled"
 )
 
 // User model
@@ -26,10 +59,49 @@ type User struct {
 	Email       string
 	Verified    bool
 	Provider    string
+	Team        string
+	Status      string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 	Preferences map[string]string
 }
+
+// Active reports whether the user may sign in
+func (u User) Active() bool {
+	return u.Status == UserActive
+}
+
+// Role returns the name of the user's access level
+func (u User) Role() string {
+	for name, level := range AccessLevels {
+		if level == u.AccessLevel {
+			return name
+		}
+	}
+	return "none"
+}
+
+// LogValue logs a user by id, role and team, leaving out their name and email
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", u.ID),
+		slog.String("role", u.Role()),
+		slog.String("status", u.Status),
+		slog.String("team", u.Team),
+	)
+}
+
+// APIToken lets a user call the broker without a browser session. Only a hash of the token is
+// stored; the token itself is shown once, when it is created.
+type APIToken struct {
+	ID         int
+	UserID     string
+	Name       string
+	TokenHash  string
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	CreatedAt  time.Time
+}
 type GoogleUserResult struct {
 	Id             string `json:"id"`
 	Email          string `json:"email"`
@@ -51,15 +123,77 @@ type UflipPayload struct {
 	TicketInfo  string `json:"ticket_info"`
 	TicketPrice string `json:"ticket_price"`
 	TicketTotal string `json:"ticket_total"`
-	Buy         bool   `json:"buy"`
+	// Quantity is the number of tickets in the cart
+	Quantity   int    `json:"quantity"`
+	Buy        bool   `json:"buy"`
+	ApprovedBy string `json:"approved_by,omitempty"`
+	// Decisions are the rules that matched the cart when it was produced
+	Decisions []RuleDecision `json:"decisions,omitempty"`
+	// SnipeOrderID is the open snipe order the cart matched, if any
+	SnipeOrderID int `json:"snipe_order_id,omitempty"`
 }
+
+var quantityRegex = regexp.MustCompile(`\d+`)
+
+// DefaultQuantity fills in a missing quantity from the ticket info, such as "2 tickets", falling
+// back to one. It reports whether it had to, for extensions that don't send quantity yet.
+func (p *UflipPayload) DefaultQuantity() bool {
+	if p.Quantity != 0 {
+		return false
+	}
+	p.Quantity = 1
+	if q, err := strconv.Atoi(quantityRegex.FindString(p.TicketInfo)); err == nil && q > 0 {
+		p.Quantity = q
+	}
+	return true
+}
+
+// Validate checks the fields that are worked out from the cart's values
+func (p UflipPayload) Validate() error {
+	if p.Quantity < 1 {
+		return ErrNoQuantity
+	}
+	if _, err := p.Total(); err != nil {
+		return fmt.Errorf("ticket total %q is not an amount", p.TicketTotal)
+	}
+	return nil
+}
+
+// PricePerTicket returns the ticket price, or the total split across the tickets when the price
+// can't be read
+func (p UflipPayload) PricePerTicket() float64 {
+	price, err := parseMoney(p.TicketPrice)
+	if err == nil {
+		return price
+	}
+	total, _ := p.Total()
+	if p.Quantity < 1 {
+		return total
+	}
+	return total / float64(p.Quantity)
+}
+
+// Total returns the ticket total as a number, ignoring any currency symbol
+func (p UflipPayload) Total() (float64, error) {
+	return parseMoney(p.TicketTotal)
+}
+
+// parseMoney reads an amount scraped from a page, ignoring any currency symbol and separators
+func parseMoney(s string) (float64, error) {
+	return strconv.ParseFloat(strings.NewReplacer("$", "", ",", "").Replace(strings.TrimSpace(s)), 64)
+}
+
 type RequestPayload struct {
-	Action  string        `json:"action"`
-	Produce UflipPayload  `json:"cart,omitempty"`
-	Buy     BuyPayload    `json:"buy,omitempty"`
-	VA      VABuyPayload  `json:"va, omitempty"`
-	Delete  DeletePayload `json:"delete,omitempty"`
-	User    UserPayload   `json:"user"`
+	Action  string         `json:"action"`
+	Produce UflipPayload   `json:"cart,omitempty"`
+	Buy     BuyPayload     `json:"buy,omitempty"`
+	VA      VABuyPayload   `json:"va,omitempty"`
+	Delete  DeletePayload  `json:"delete,omitempty"`
+	Confirm ConfirmPayload `json:"confirm,omitempty"`
+	Extend  ExtendPayload  `json:"extend,omitempty"`
+	// User is who the extension says is signed in. It is never trusted: the broker acts for the
+	// user its session, api token or Google token authenticates.
+	User UserPayload `json:"user"`
 }
 type UserPayload struct {
 	Email string `json:"email"`
@@ -69,6 +203,38 @@ type BuyPayload struct {
 	Buy  bool   `json:"buy"`
 	UUID string `json:"uuid"`
 }
+
+// ConfirmPayload is sent by the agent once checkout has finished in the cart's tab. Amounts are
+// sent as they appear on the checkout page. The attachment is a base64 screenshot or PDF.
+type ConfirmPayload struct {
+	UUID           string `json:"uuid"`
+	OrderNumber    string `json:"order_number"`
+	ChargedTotal   string `json:"charged_total"`
+	Fees           string `json:"fees"`
+	Attachment     string `json:"attachment,omitempty"`
+	AttachmentName string `json:"attachment_name,omitempty"`
+}
+
+// Amounts returns the charged total and fees as numbers
+func (p ConfirmPayload) Amounts() (charged, fees float64, err error) {
+	charged, err = parseMoney(p.ChargedTotal)
+	if err != nil {
+		return 0, 0, fmt.Errorf("charged total %q is not an amount", p.ChargedTotal)
+	}
+	if strings.TrimSpace(p.Fees) != "" {
+		fees, err = parseMoney(p.Fees)
+		if err != nil {
+			return 0, 0, fmt.Errorf("fees %q is not an amount", p.Fees)
+		}
+	}
+	return charged, fees, nil
+}
+
+// ExtendPayload asks for a live cart to be kept for longer
+type ExtendPayload struct {
+	UUID string `json:"uuid"`
+}
+
 type VABuyPayload struct {
 	RedisKey string `json:"key"`
 }
@@ -80,3 +246,445 @@ type JsonResponse struct {
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
+
+// Cart is a row in the carts table
+type Cart struct {
+	ID          string    `json:"id"`
+	EventDate   string    `json:"event_date"`
+	EventName   string    `json:"event_name"`
+	EventVenue  string    `json:"event_venue"`
+	SeatInfo    string    `json:"seat_info"`
+	TicketInfo  string    `json:"ticket_info"`
+	TicketPrice string    `json:"ticket_price"`
+	TicketTotal float64   `json:"ticket_total"`
+	Quantity    int       `json:"quantity"`
+	Bought      bool      `json:"bought"`
+	StockType   string    `json:"stock_type"`
+	UserID      string    `json:"user_id"`
+	BoughtBy    string    `json:"bought_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// inventory states
+const (
+	InventoryPurchased   = "purchased"
+	InventoryListed      = "listed"
+	InventorySold        = "sold"
+	InventoryTransferred = "transferred"
+	InventoryDelivered   = "delivered"
+	InventoryRefunded    = "refunded"
+)
+
+// InventoryStates lists every inventory state in lifecycle order
+var InventoryStates = []string{
+	InventoryPurchased,
+	InventoryListed,
+	InventorySold,
+	InventoryTransferred,
+	InventoryDelivered,
+	InventoryRefunded,
+}
+
+// inventoryTransitions maps a state to the states it may move to
+var inventoryTransitions = map[string][]string{
+	InventoryPurchased:   {InventoryListed, InventoryTransferred, InventoryRefunded},
+	InventoryListed:      {InventoryPurchased, InventorySold, InventoryRefunded},
+	InventorySold:        {InventoryTransferred, InventoryDelivered, InventoryRefunded},
+	InventoryTransferred: {InventoryDelivered, InventoryRefunded},
+	InventoryDelivered:   {InventoryRefunded},
+	InventoryRefunded:    {},
+}
+
+// InventoryItem is a purchased ticket lot we are flipping
+type InventoryItem struct {
+	ID           int       `json:"id"`
+	CartID       string    `json:"cart_id"`
+	UserID       string    `json:"user_id"`
+	EventDate    string    `json:"event_date"`
+	EventName    string    `json:"event_name"`
+	EventVenue   string    `json:"event_venue"`
+	SeatInfo     string    `json:"seat_info"`
+	State        string    `json:"state"`
+	Quantity     int       `json:"quantity"`
+	CostBasis    float64   `json:"cost_basis"`
+	Fees         float64   `json:"fees"`
+	ListingPrice float64   `json:"listing_price"`
+	SalePrice    float64   `json:"sale_price"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// NextStates returns the states the item is allowed to move to
+func (i InventoryItem) NextStates() []string {
+	return inventoryTransitions[i.State]
+}
+
+// CanTransition reports whether the item may move to state
+func (i InventoryItem) CanTransition(state string) bool {
+	for _, s := range inventoryTransitions[i.State] {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// budget scopes
+const (
+	BudgetBuyer = "buyer"
+	BudgetTeam  = "team"
+	BudgetEvent = "event"
+)
+
+// BudgetScopes lists every budget scope
+var BudgetScopes = []string{BudgetBuyer, BudgetTeam, BudgetEvent}
+
+// BudgetPeriods lists the windows a budget can reset over
+var BudgetPeriods = []string{"day", "week", "month", "total"}
+
+// Budget caps spend for a buyer, a buyer's team or an event over a period.
+// Key is the buyer's user id, the team name or the event name.
+type Budget struct {
+	ID        int
+	Scope     string
+	Key       string
+	Amount    float64
+	Period    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// PeriodStart returns the start of the budget window containing now
+func (b Budget) PeriodStart(now time.Time) time.Time {
+	y, m, d := now.Date()
+	switch b.Period {
+	case "day":
+		return time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	case "week":
+		offset := (int(now.Weekday()) + 6) % 7 // weeks start on monday
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, now.Location())
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, now.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// BudgetStatus is a budget with the amount spent in its current window
+type BudgetStatus struct {
+	Budget
+	Spent float64
+}
+
+// Remaining returns how much can still be spent, never less than zero
+func (s BudgetStatus) Remaining() float64 {
+	if s.Spent >= s.Amount {
+		return 0
+	}
+	return s.Amount - s.Spent
+}
+
+// BudgetExceededError is returned when a buy would take a budget over its cap
+type BudgetExceededError struct {
+	Status BudgetStatus
+	Total  float64
+}
+
+func (e *BudgetExceededError) Error() string {
+	return fmt.Sprintf("%s budget for %s exceeded: $%.2f of $%.2f per %s spent, this buy is $%.2f",
+		e.Status.Scope, e.Status.Key, e.Status.Spent, e.Status.Amount, e.Status.Period, e.Total)
+}
+
+// Confirmation is what the agent reported after checking out a bought cart, reconciled against the
+// total the buyers approved
+type Confirmation struct {
+	ID            int       `json:"id"`
+	CartID        string    `json:"cart_id"`
+	UserID        string    `json:"user_id"`
+	EventName     string    `json:"event_name"`
+	OrderNumber   string    `json:"order_number"`
+	ChargedTotal  float64   `json:"charged_total"`
+	Fees          float64   `json:"fees"`
+	ApprovedTotal float64   `json:"approved_total"`
+	Discrepancy   float64   `json:"discrepancy"`
+	Flagged       bool      `json:"flagged"`
+	AttachmentID  int       `json:"attachment_id,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Attachment is a stored file linked to a cart or an inventory item. The file itself is kept in
+// the attachment store under its SHA-256.
+type Attachment struct {
+	ID          int       `json:"id"`
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename"`
+	CartID      string    `json:"cart_id,omitempty"`
+	InventoryID int       `json:"inventory_id,omitempty"`
+	UserID      string    `json:"user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// cart event types
+const (
+	CartProduced  = "produced"
+	CartDeclined  = "declined"
+	CartClaimed   = "claimed"
+	CartBought    = "bought"
+	CartExtended  = "extended"
+	CartExpired   = "expired"
+	CartConfirmed = "confirmed"
+)
+
+// CartEvent is one entry in a cart's append-only history. Data holds what the event carried: the
+// cart itself when it was produced, the time to live when it was produced or extended, and so on.
+type CartEvent struct {
+	ID        int64           `json:"id"`
+	CartID    string          `json:"cart_id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// outbox message kinds
+const (
+	OutboxRedisSet = "redis.set"
+	OutboxTrigger  = "pusher.trigger"
+)
+
+// OutboxMessage is a side effect written in the same transaction as the rows it belongs to, and
+// applied afterwards by the outbox dispatcher. Messages with the same key are applied in order.
+type OutboxMessage struct {
+	ID            int64           `json:"id"`
+	Kind          string          `json:"kind"`
+	Key           string          `json:"key"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// job statuses
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a unit of background work. Queued jobs are picked up by the worker pool once RunAt has
+// passed; a job that errors is retried with backoff until it has had MaxAttempts tries.
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	UserID      string          `json:"user_id,omitempty"`
+	FinishedAt  time.Time       `json:"finished_at"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// rule actions
+const (
+	RuleApprove = "approve"
+	RuleDecline = "decline"
+	RuleFlag    = "flag"
+	RuleNotify  = "notify"
+)
+
+// RuleActions lists every rule action
+var RuleActions = []string{RuleApprove, RuleDecline, RuleFlag, RuleNotify}
+
+// Rule acts on produced carts that meet all of its conditions. Empty or zero conditions match anything.
+type Rule struct {
+	ID          int
+	UserID      string
+	Name        string
+	Priority    int
+	Enabled     bool
+	Action      string
+	EventName   string
+	Venue       string
+	Sections    string
+	MinPrice    float64
+	MaxPrice    float64
+	MinQuantity int
+	MaxQuantity int
+	StockType   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// RuleDecision records a rule matching a cart, and why
+type RuleDecision struct {
+	CartID    string    `json:"cart_id"`
+	RuleID    int       `json:"rule_id"`
+	RuleName  string    `json:"rule_name"`
+	UserID    string    `json:"user_id"`
+	Action    string    `json:"action"`
+	Reasons   []string  `json:"reasons"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Explanation describes the decision in one line
+func (d RuleDecision) Explanation() string {
+	return fmt.Sprintf("%s (%s): %s", d.RuleName, d.Action, strings.Join(d.Reasons, "; "))
+}
+
+// snipe order statuses
+const (
+	OrderOpen      = "open"
+	OrderFulfilled = "fulfilled"
+	OrderCancelled = "cancelled"
+	OrderExpired   = "expired"
+)
+
+// SnipeOrder is a buyer asking agents to find seats, e.g. 2 seats for an event in the lower bowl under $150 each
+type SnipeOrder struct {
+	ID              int       `json:"id"`
+	UserID          string    `json:"user_id"`
+	EventName       string    `json:"event_name"`
+	Venue           string    `json:"venue,omitempty"`
+	Sections        string    `json:"sections,omitempty"`
+	MaxPrice        float64   `json:"max_price,omitempty"`
+	Quantity        int       `json:"quantity"`
+	AutoApprove     bool      `json:"auto_approve"`
+	Notes           string    `json:"notes,omitempty"`
+	Status          string    `json:"status"`
+	FulfilledCartID string    `json:"fulfilled_cart_id,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Rule returns the order's conditions as a rule, so carts are matched the same way rules match them
+func (o SnipeOrder) Rule() Rule {
+	action := RuleFlag
+	if o.AutoApprove {
+		action = RuleApprove
+	}
+	return Rule{
+		ID:          o.ID,
+		UserID:      o.UserID,
+		Name:        fmt.Sprintf("snipe order #%d", o.ID),
+		Enabled:     true,
+		Action:      action,
+		EventName:   o.EventName,
+		Venue:       o.Venue,
+		Sections:    o.Sections,
+		MaxPrice:    o.MaxPrice,
+		MinQuantity: o.Quantity,
+		MaxQuantity: o.Quantity,
+	}
+}
+
+// ReportFilter limits reports to carts created in [From, To)
+type ReportFilter struct {
+	From   time.Time
+	To     time.Time
+	Period string
+}
+
+// ExportFilter limits exported carts and purchases. Empty fields match everything.
+type ExportFilter struct {
+	From  time.Time
+	To    time.Time
+	State string
+	Event string
+	Agent string
+	Team  string
+}
+
+// AuditEvent records who did what to which record, and from where. Before and After hold the
+// record's state as JSON, when there is one.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	UserAgent  string          `json:"user_agent,omitempty"`
+	Method     string          `json:"method,omitempty"`
+	Path       string          `json:"path,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditFilter narrows the audit log. Zero values match everything.
+type AuditFilter struct {
+	From       time.Time
+	To         time.Time
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+}
+
+// ImportResult summarises a bulk import. In a dry run, Imported counts the rows that would be imported.
+type ImportResult struct {
+	DryRun   bool              `json:"dry_run"`
+	Rows     int               `json:"rows"`
+	Imported int               `json:"imported"`
+	Skipped  int               `json:"skipped"`
+	Errors   []ImportRowErrors `json:"errors,omitempty"`
+}
+
+// ImportedPurchase is a bought cart and its inventory row read from line Line of an import file
+type ImportedPurchase struct {
+	Line int
+	Cart Cart
+	Item InventoryItem
+}
+
+// ImportRowErrors holds the validation errors for one line of an import file
+type ImportRowErrors struct {
+	Line   int                 `json:"line"`
+	Errors map[string][]string `json:"errors"`
+}
+
+// EventReport is profit and loss for a single event
+type EventReport struct {
+	EventName     string  `json:"event_name"`
+	EventDate     string  `json:"event_date"`
+	Items         int     `json:"items"`
+	Quantity      int     `json:"quantity"`
+	Cost          float64 `json:"cost"`
+	Fees          float64 `json:"fees"`
+	Revenue       float64 `json:"revenue"`
+	RealizedPnL   float64 `json:"realized_pnl"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	ROI           float64 `json:"roi"`
+}
+
+// SpendReport is spend and profit and loss grouped by agent, team or period
+type SpendReport struct {
+	Key           string  `json:"key"`
+	Name          string  `json:"name,omitempty"`
+	Team          string  `json:"team,omitempty"`
+	Carts         int     `json:"carts"`
+	Spend         float64 `json:"spend"`
+	RealizedPnL   float64 `json:"realized_pnl"`
+	UnrealizedPnL float64 `json:"unrealized_pnl"`
+	ROI           float64 `json:"roi"`
+}
+
+// ReturnOnInvestment returns pnl as a fraction of cost, or zero when nothing was spent
+func ReturnOnInvestment(pnl, cost float64) float64 {
+	if cost == 0 {
+		return 0
+	}
+	return pnl / cost
+}
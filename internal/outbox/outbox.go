@@ -0,0 +1,167 @@
+// Package outbox applies side effects that were written to Postgres in the same transaction as the
+// rows they belong to. Live cart writes and Pusher broadcasts are retried with backoff until they
+// succeed, so a crash or an outage between steps can't leave the stores disagreeing.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/livecarts"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/SeatSnobAri/seatflipsite/internal/repository"
+	"github.com/SeatSnobAri/seatflipsite/internal/tracing"
+	"log/slog"
+	"time"
+)
+
+const (
+	// batchSize is how many messages one pass dispatches
+	batchSize = 20
+	// pollInterval is how often the dispatcher looks for due messages when nobody has notified it
+	pollInterval = time.Second
+	// firstRetry is the wait after the first failure; it doubles with every failure after that
+	firstRetry = time.Second
+	// maxRetry caps the wait between attempts
+	maxRetry = 5 * time.Minute
+	// maxAttempts is how many times a message is tried before it is given up on
+	maxAttempts = 20
+)
+
+// RedisSet is the payload of a redis.set message, which puts a cart in the live cart store
+type RedisSet struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+	TTL   time.Duration   `json:"ttl"`
+}
+
+// Trigger is the payload of a pusher.trigger message
+type Trigger struct {
+	Channel string            `json:"channel"`
+	Event   string            `json:"event"`
+	Data    map[string]string `json:"data"`
+}
+
+// Notifier sends realtime events; the pusher client is one
+type Notifier interface {
+	Trigger(channel string, eventName string, data interface{}) error
+}
+
+// Set returns a message that makes v live, as json under key, for ttl. Key orders it with the
+// other messages for the same record.
+func Set(key string, v any, ttl time.Duration) (models.OutboxMessage, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	return message(models.OutboxRedisSet, key, RedisSet{Key: key, Value: value, TTL: ttl})
+}
+
+// Broadcast returns a message that triggers event on channel
+func Broadcast(key, channel, event string, data map[string]string) (models.OutboxMessage, error) {
+	return message(models.OutboxTrigger, key, Trigger{Channel: channel, Event: event, Data: data})
+}
+
+func message(kind, key string, payload any) (models.OutboxMessage, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+	return models.OutboxMessage{Kind: kind, Key: key, Payload: b}, nil
+}
+
+// Dispatcher applies outbox messages
+type Dispatcher struct {
+	db       repository.DatabaseRepo
+	carts    livecarts.Store
+	notifier Notifier
+	wake     chan struct{}
+}
+
+// New returns a dispatcher that applies messages to carts and notifier
+func New(db repository.DatabaseRepo, carts livecarts.Store, notifier Notifier) *Dispatcher {
+	return &Dispatcher{
+		db:       db,
+		carts:    carts,
+		notifier: notifier,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the dispatcher because new messages were written. It never blocks.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run dispatches messages as they come due until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+
+		err := d.Dispatch(ctx)
+		if err != nil {
+			slog.Error("outbox: dispatch failed", "err", err)
+		}
+	}
+}
+
+// Dispatch applies every message that is due now
+func (d *Dispatcher) Dispatch(ctx context.Context) error {
+	for {
+		n, err := d.db.WithContext(ctx).DispatchOutbox(batchSize, func(m models.OutboxMessage) error {
+			return d.apply(ctx, m)
+		}, Backoff)
+		if err != nil || n < batchSize || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// apply carries out one message
+func (d *Dispatcher) apply(ctx context.Context, m models.OutboxMessage) error {
+	switch m.Kind {
+	case models.OutboxRedisSet:
+		var p RedisSet
+		if err := json.Unmarshal(m.Payload, &p); err != nil {
+			return err
+		}
+		return d.carts.Put(ctx, p.Key, p.Value, p.TTL)
+	case models.OutboxTrigger:
+		var p Trigger
+		if err := json.Unmarshal(m.Payload, &p); err != nil {
+			return err
+		}
+		return tracing.Trigger(ctx, p.Channel, p.Event, func() error {
+			return d.notifier.Trigger(p.Channel, p.Event, p.Data)
+		})
+	default:
+		return fmt.Errorf("unknown message kind %q", m.Kind)
+	}
+}
+
+// Backoff waits firstRetry after the first failure and doubles the wait after every failure that
+// follows, up to maxRetry. After maxAttempts it gives up.
+func Backoff(attempts int) (time.Duration, bool) {
+	if attempts >= maxAttempts {
+		return 0, false
+	}
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	if wait > maxRetry {
+		wait = maxRetry
+	}
+	return wait, true
+}
@@ -6,10 +6,9 @@ import (
 	"fmt"
 	"github.com/SeatSnobAri/seatflipsite/internal/config"
 	"github.com/SeatSnobAri/seatflipsite/internal/helpers"
+	"github.com/SeatSnobAri/seatflipsite/internal/logging"
 	"github.com/SeatSnobAri/seatflipsite/internal/templates"
-
 	"html/template"
-	"log"
 	"net/http"
 	"path/filepath"
 	"time"
@@ -20,6 +19,8 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"money":      Money,
+	"percent":    Percent,
 }
 
 var app *config.AppConfig
@@ -29,6 +30,19 @@ func Add(a, b int) int {
 	return a + b
 }
 
+// Money formats an amount in dollars
+func Money(f float64) string {
+	if f < 0 {
+		return fmt.Sprintf("-$%.2f", -f)
+	}
+	return fmt.Sprintf("$%.2f", f)
+}
+
+// Percent formats a fraction as a percentage
+func Percent(f float64) string {
+	return fmt.Sprintf("%.1f%%", f*100)
+}
+
 // Iterate returns a slice of ints, starting at 1, going to count
 func Iterate(count int) []int {
 	var i int
@@ -90,11 +104,13 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *templates
 
 	err := t.Execute(buf, td)
 	if err != nil {
-		log.Fatal(err)
+		// nothing has been written yet, so the error page can still be sent
+		helpers.ServerError(w, r, fmt.Errorf("executing %s: %w", tmpl, err))
+		return err
 	}
 	_, err = buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("Error writing template to browser", err)
+		logging.FromContext(r.Context()).Error("can't write template to browser", "template", tmpl, "err", err)
 		return err
 	}
 
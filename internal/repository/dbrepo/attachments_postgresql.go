@@ -0,0 +1,108 @@
+package dbrepo
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// InsertAttachment links a stored file to a cart or an inventory item
+func (repo *postgresDBRepo) InsertAttachment(a models.Attachment) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "storage".attachments (sha256, size, content_type, filename, cart_id, inventory_id, user_id)
+			values ($1, $2, $3, $4, nullif($5, ''), nullif($6, 0), $7) returning id`
+
+	var newId int
+	err := repo.DB.QueryRowContext(ctx, stmt,
+		a.SHA256,
+		a.Size,
+		a.ContentType,
+		a.Filename,
+		a.CartID,
+		a.InventoryID,
+		a.UserID,
+	).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// GetAttachmentById returns an attachment by id
+func (repo *postgresDBRepo) GetAttachmentById(id int) (models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, sha256, size, content_type, filename, coalesce(cart_id, ''), coalesce(inventory_id, 0),
+       			user_id, created_at
+			from "storage".attachments where id = $1`
+
+	var a models.Attachment
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(
+		&a.ID,
+		&a.SHA256,
+		&a.Size,
+		&a.ContentType,
+		&a.Filename,
+		&a.CartID,
+		&a.InventoryID,
+		&a.UserID,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+// InventoryAttachments returns the attachments of every inventory item keyed by item id, including
+// those linked to the cart the item was bought from
+func (repo *postgresDBRepo) InventoryAttachments() (map[int][]models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select i.id, a.id, a.sha256, a.size, a.content_type, a.filename, coalesce(a.cart_id, ''),
+       			coalesce(a.inventory_id, 0), a.user_id, a.created_at
+			from "storage".attachments a
+			join "inventory".inventory i on i.id = a.inventory_id or i.cart_id = a.cart_id
+			order by a.created_at`
+
+	rows, err := repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[int][]models.Attachment)
+
+	for rows.Next() {
+		var itemID int
+		var a models.Attachment
+		err = rows.Scan(
+			&itemID,
+			&a.ID,
+			&a.SHA256,
+			&a.Size,
+			&a.ContentType,
+			&a.Filename,
+			&a.CartID,
+			&a.InventoryID,
+			&a.UserID,
+			&a.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attachments[itemID] = append(attachments[itemID], a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,149 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"strings"
+	"time"
+)
+
+const auditColumns = `id, actor, action, target_type, target_id, before, after, ip, user_agent, method, path, created_at`
+
+// InsertAuditEvent appends an event to the audit log
+func (repo *postgresDBRepo) InsertAuditEvent(e models.AuditEvent) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "audit".audit_events (actor, action, target_type, target_id, before, after, ip, user_agent,
+                                   method, path)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := repo.DB.ExecContext(ctx, stmt,
+		e.Actor,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		nullJSON(e.Before),
+		nullJSON(e.After),
+		e.IP,
+		e.UserAgent,
+		e.Method,
+		e.Path,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AuditEvents returns the most recent audit events matching the filter
+func (repo *postgresDBRepo) AuditEvents(f models.AuditFilter, limit int) ([]models.AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	where, args := auditWhere(f)
+	args = append(args, limit)
+
+	query := `select ` + auditColumns + ` from "audit".audit_events ` + where +
+		fmt.Sprintf(` order by created_at desc, id desc limit $%d`, len(args))
+
+	var events []models.AuditEvent
+	err := repo.scanAuditEvents(ctx, query, args, func(e models.AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// ExportAuditEvents streams audit events matching the filter to fn, oldest first
+func (repo *postgresDBRepo) ExportAuditEvents(ctx context.Context, f models.AuditFilter, fn func(models.AuditEvent) error) error {
+	where, args := auditWhere(f)
+
+	query := `select ` + auditColumns + ` from "audit".audit_events ` + where + ` order by created_at, id`
+
+	return repo.scanAuditEvents(ctx, query, args, fn)
+}
+
+func (repo *postgresDBRepo) scanAuditEvents(ctx context.Context, query string, args []any, fn func(models.AuditEvent) error) error {
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEvent
+		var before, after []byte
+		err = rows.Scan(
+			&e.ID,
+			&e.Actor,
+			&e.Action,
+			&e.TargetType,
+			&e.TargetID,
+			&before,
+			&after,
+			&e.IP,
+			&e.UserAgent,
+			&e.Method,
+			&e.Path,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		e.Before, e.After = before, after
+		if err = fn(e); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// auditWhere builds the where clause and arguments for an audit filter
+func auditWhere(f models.AuditFilter) (string, []any) {
+	var where []string
+	var args []any
+
+	add := func(clause string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		add("target_type = $%d", f.TargetType)
+	}
+	if f.TargetID != "" {
+		add("target_id = $%d", f.TargetID)
+	}
+
+	if len(where) == 0 {
+		return "", args
+	}
+	return "where " + strings.Join(where, " and "), args
+}
+
+// nullJSON stores an empty document as null rather than invalid json
+func nullJSON(b []byte) any {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
@@ -0,0 +1,272 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// budgetSpentQuery sums bought carts that count against a budget since a point in time
+const budgetSpentQuery = `select coalesce(sum(c.ticket_total), 0)
+	from "carts".carts c
+		left join "users".users u on u.id = c.bought_by
+	where c.bought and c.bought_at >= $1
+		and case $2
+			when 'buyer' then c.bought_by = $3
+			when 'team' then u.team = $3
+			else c.event_name = $3
+		end`
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// AllBudgets returns every budget
+func (repo *postgresDBRepo) AllBudgets() ([]models.Budget, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, scope, scope_key, amount, period, created_at, updated_at
+			from "budgets".budgets order by scope, scope_key`
+
+	return scanBudgets(ctx, repo.DB, query)
+}
+
+// SaveBudget creates a budget, or replaces the amount and period of the budget with the same scope and key
+func (repo *postgresDBRepo) SaveBudget(b models.Budget) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "budgets".budgets (scope, scope_key, amount, period) values ($1, $2, $3, $4)
+			on conflict (scope, scope_key) do update set amount = excluded.amount, period = excluded.period`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, b.Scope, b.Key, b.Amount, b.Period)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteBudget removes a budget
+func (repo *postgresDBRepo) DeleteBudget(id int) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `delete from "budgets".budgets where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// BudgetStatus returns the budgets a buyer's purchases count against, with what has been spent so far
+func (repo *postgresDBRepo) BudgetStatus(buyerID string) ([]models.BudgetStatus, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select b.id, b.scope, b.scope_key, b.amount, b.period, b.created_at, b.updated_at
+			from "budgets".budgets b
+			where (b.scope = 'buyer' and b.scope_key = $1)
+				or (b.scope = 'team' and b.scope_key = (select team from "users".users where id = $1))
+				or b.scope = 'event'
+			order by b.scope, b.scope_key`
+
+	budgets, err := scanBudgets(ctx, repo.DB, query, buyerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return budgetStatuses(ctx, repo.DB, budgets)
+}
+
+// BuyCart marks a cart as bought by buyerID. Unless override is set, every buyer, team and event budget
+// the cart counts against is locked and checked first, so concurrent buys cannot overspend a cap between
+// them; a *models.BudgetExceededError is returned when a cap would be exceeded. A buy made for snipe
+// order orderID is refused with models.ErrOrderNotOpen once the order has been cancelled, expired or
+// fulfilled.
+func (repo *postgresDBRepo) BuyCart(cartID, buyerID string, override bool, orderID int) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 5*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var total float64
+	var event string
+	var bought bool
+	query := `select ticket_total, event_name, bought from "carts".carts where id = $1 for update`
+	err = tx.QueryRowContext(ctx, query, cartID).Scan(&total, &event, &bought)
+	if err == sql.ErrNoRows {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+	if bought {
+		return models.ErrAlreadyBought
+	}
+
+	if orderID != 0 {
+		// the order stays locked so it can't be cancelled or fulfilled under this buy
+		var status string
+		query = `select status from "orders".snipe_orders where id = $1 for update`
+		err = tx.QueryRowContext(ctx, query, orderID).Scan(&status)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if status != models.OrderOpen {
+			return models.ErrOrderNotOpen
+		}
+	}
+
+	if !override {
+		// lock in id order so concurrent buys can't deadlock on each other
+		query = `select b.id, b.scope, b.scope_key, b.amount, b.period, b.created_at, b.updated_at
+			from "budgets".budgets b
+			where (b.scope = 'buyer' and b.scope_key = $1)
+				or (b.scope = 'team' and b.scope_key = (select team from "users".users where id = $1))
+				or (b.scope = 'event' and b.scope_key = $2)
+			order by b.id
+			for update`
+
+		budgets, err := scanBudgets(ctx, tx, query, buyerID, event)
+		if err != nil {
+			return err
+		}
+
+		statuses, err := budgetStatuses(ctx, tx, budgets)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			if s.Spent+total > s.Amount {
+				return &models.BudgetExceededError{Status: s, Total: total}
+			}
+		}
+	}
+
+	stmt := `update "carts".carts set bought = true, bought_by = $1, bought_at = now(), override_requested = false
+			where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, buyerID, cartID)
+	if err != nil {
+		return err
+	}
+
+	// buying a cart fulfils the snipe order it matched
+	stmt = `update "orders".snipe_orders set status = 'fulfilled', fulfilled_cart_id = $1
+			where status = 'open' and id = (select snipe_order_id from "carts".carts where id = $1)`
+	_, err = tx.ExecContext(ctx, stmt, cartID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RequestOverride flags a cart that was refused by a budget so an admin can approve it
+func (repo *postgresDBRepo) RequestOverride(cartID string, requested bool) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "carts".carts set override_requested = $1 where id = $2 and not bought`
+	_, err := repo.DB.ExecContext(ctx, stmt, requested, cartID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// PendingOverrides returns carts waiting for an admin to override a budget
+func (repo *postgresDBRepo) PendingOverrides() ([]models.Cart, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, event_date, event_name, event_venue, seat_info, ticket_info, ticket_price, ticket_total,
+       			quantity, stock_type, user_id, created_at
+			from "carts".carts where override_requested and not bought order by created_at`
+
+	rows, err := repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var carts []models.Cart
+
+	for rows.Next() {
+		var c models.Cart
+		err = rows.Scan(
+			&c.ID,
+			&c.EventDate,
+			&c.EventName,
+			&c.EventVenue,
+			&c.SeatInfo,
+			&c.TicketInfo,
+			&c.TicketPrice,
+			&c.TicketTotal,
+			&c.Quantity,
+			&c.StockType,
+			&c.UserID,
+			&c.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		carts = append(carts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return carts, nil
+}
+
+// scanBudgets runs a budget query and scans its rows
+func scanBudgets(ctx context.Context, q queryer, query string, args ...any) ([]models.Budget, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var budgets []models.Budget
+
+	for rows.Next() {
+		var b models.Budget
+		err = rows.Scan(&b.ID, &b.Scope, &b.Key, &b.Amount, &b.Period, &b.CreatedAt, &b.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return budgets, nil
+}
+
+// budgetStatuses adds the amount spent in the current window to each budget
+func budgetStatuses(ctx context.Context, q queryer, budgets []models.Budget) ([]models.BudgetStatus, error) {
+	now := time.Now()
+	statuses := make([]models.BudgetStatus, 0, len(budgets))
+
+	for _, b := range budgets {
+		s := models.BudgetStatus{Budget: b}
+		err := q.QueryRowContext(ctx, budgetSpentQuery, b.PeriodStart(now), b.Scope, b.Key).Scan(&s.Spent)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, nil
+}
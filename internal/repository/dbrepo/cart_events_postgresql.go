@@ -0,0 +1,132 @@
+package dbrepo
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+const cartEventColumns = `id, cart_id, type, actor, data, created_at`
+
+// InsertCartEvent appends an event to a cart's history
+func (repo *postgresDBRepo) InsertCartEvent(e models.CartEvent) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "carts".cart_events (cart_id, type, actor, data) values ($1, $2, $3, $4)`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, e.CartID, e.Type, e.Actor, nullJSON(e.Data))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CartEvents returns a cart's history, oldest first
+func (repo *postgresDBRepo) CartEvents(cartID string) ([]models.CartEvent, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + cartEventColumns + ` from "carts".cart_events where cart_id = $1 order by id`
+
+	return repo.scanCartEvents(ctx, query, cartID)
+}
+
+// CartEventsAt returns the history up to at of every cart produced in the window before at, oldest
+// first. It is everything needed to replay the dashboard as it was at that moment.
+func (repo *postgresDBRepo) CartEventsAt(at time.Time, window time.Duration) ([]models.CartEvent, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + cartEventColumns + ` from "carts".cart_events
+			where created_at <= $1 and cart_id in (
+				select cart_id from "carts".cart_events
+				where type = 'produced' and created_at > $2 and created_at <= $1)
+			order by id`
+
+	return repo.scanCartEvents(ctx, query, at, at.Add(-window))
+}
+
+// InsertCartExpiry records that a cart expired, unless it already has. It reports whether it did,
+// and ignores ids that aren't carts.
+func (repo *postgresDBRepo) InsertCartExpiry(cartID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "carts".cart_events (cart_id, type)
+			select $1, 'expired'
+			where exists (select 1 from "carts".carts where id = $1)
+			  and not exists (select 1 from "carts".cart_events where cart_id = $1 and type = 'expired')`
+
+	res, err := repo.DB.ExecContext(ctx, stmt, cartID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UnexpiredCarts returns the ids of carts produced since since that haven't been bought, declined or
+// expired. Declined carts never went live, so there's nothing to expire, and carts whose live cart
+// writes are still waiting in the outbox are left out, since they aren't live yet.
+func (repo *postgresDBRepo) UnexpiredCarts(since time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select c.id from "carts".carts c
+			where not c.bought and c.created_at > $1
+			  and not exists (select 1 from "carts".cart_events e where e.cart_id = c.id and e.type in ('expired', 'declined'))
+			  and not exists (select 1 from outbox.messages m
+			                  where m.key = c.id and m.dispatched_at is null and m.failed_at is null)`
+
+	rows, err := repo.DB.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (repo *postgresDBRepo) scanCartEvents(ctx context.Context, query string, args ...any) ([]models.CartEvent, error) {
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.CartEvent
+
+	for rows.Next() {
+		var e models.CartEvent
+		var data []byte
+		err = rows.Scan(
+			&e.ID,
+			&e.CartID,
+			&e.Type,
+			&e.Actor,
+			&data,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		e.Data = data
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -0,0 +1,32 @@
+package dbrepo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnexpiredCarts(t *testing.T) {
+	repo := newTestRepo(t)
+	seed(t, repo.DB,
+		`insert into "users".users (id, first_name, last_name) values ('ann', 'Ann', 'Agent')`,
+		`insert into "carts".carts (id, event_name, ticket_total, quantity, bought, user_id) values
+			('open', 'Show A', 100, 2, false, 'ann'),
+			('bought', 'Show A', 100, 2, true, 'ann'),
+			('expired', 'Show A', 100, 2, false, 'ann'),
+			('declined', 'Show A', 100, 2, false, 'ann')`,
+		`insert into "carts".cart_events (cart_id, type) values
+			('open', 'produced'),
+			('bought', 'produced'), ('bought', 'bought'),
+			('expired', 'produced'), ('expired', 'expired'),
+			('declined', 'produced'), ('declined', 'declined')`,
+	)
+
+	ids, err := repo.UnexpiredCarts(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"open"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("UnexpiredCarts = %v, want %v", ids, want)
+	}
+}
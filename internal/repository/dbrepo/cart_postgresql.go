@@ -3,25 +3,30 @@ package dbrepo
 import (
 	"context"
 	"github.com/SeatSnobAri/seatflipsite/internal/models"
-	"strconv"
-	"strings"
 	"time"
 )
 
-func (repo *postgresDBRepo) InsertCart(payload models.UflipPayload, user models.UserPayload) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertCart adds a cart produced by userID. Any outbox messages are written in the same transaction, so the
+// cart's redis entry and broadcasts happen if and only if the cart row exists.
+func (repo *postgresDBRepo) InsertCart(payload models.UflipPayload, userID string, msgs ...models.OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
 	query := `insert into "carts".carts (id, event_date, event_name, event_venue, seat_info, ticket_info, ticket_price,
-                         ticket_total, bought, stock_type, user_id) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`
+                         ticket_total, quantity, bought, stock_type, user_id) values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`
 
-	tt := strings.ReplaceAll(payload.TicketTotal, "$", "")
-	ticket_total, err := strconv.ParseFloat(tt, 64)
+	ticket_total, err := payload.Total()
 	if err != nil {
 		return err
 	}
 
-	_, err = repo.DB.ExecContext(ctx, query,
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query,
 		payload.UUID,
 		payload.EventDate,
 		payload.EventName,
@@ -30,19 +35,25 @@ func (repo *postgresDBRepo) InsertCart(payload models.UflipPayload, user models.
 		payload.TicketInfo,
 		payload.TicketPrice,
 		ticket_total,
+		payload.Quantity,
 		payload.Buy,
 		payload.StockType,
-		user.Id,
+		userID,
 	)
 	if err != nil {
 		return err
 	}
-	return nil
 
+	err = insertOutbox(ctx, tx, msgs)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (repo *postgresDBRepo) UpdateCart(buy bool, id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 	query := `update "carts".carts set bought=$1 where id = $2`
 
@@ -55,7 +66,7 @@ func (repo *postgresDBRepo) UpdateCart(buy bool, id string) error {
 }
 
 func (repo *postgresDBRepo) GetCartUser(id string) string {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 	query := `select user_id from "carts".carts where id=$1`
 	var userId string
@@ -64,3 +75,105 @@ func (repo *postgresDBRepo) GetCartUser(id string) string {
 	return userId
 
 }
+
+// GetCartById returns a cart by id
+func (repo *postgresDBRepo) GetCartById(id string) (models.Cart, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, event_date, event_name, event_venue, seat_info, ticket_info, ticket_price, ticket_total,
+       			quantity, bought, stock_type, user_id, coalesce(bought_by, ''), created_at, updated_at
+			from "carts".carts where id = $1`
+
+	var c models.Cart
+	row := repo.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&c.ID,
+		&c.EventDate,
+		&c.EventName,
+		&c.EventVenue,
+		&c.SeatInfo,
+		&c.TicketInfo,
+		&c.TicketPrice,
+		&c.TicketTotal,
+		&c.Quantity,
+		&c.Bought,
+		&c.StockType,
+		&c.UserID,
+		&c.BoughtBy,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// AddApproval records userID approving a cart and returns how many different buyers have approved it.
+// Approvals of a cart are counted under its row lock, so of two buyers approving at once exactly
+// one sees the second approval.
+func (repo *postgresDBRepo) AddApproval(cartID, userID string) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `select id from "carts".carts where id = $1 for update`, cartID).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into "carts".approvals (cart_id, user_id) values ($1, $2) on conflict do nothing`
+	_, err = tx.ExecContext(ctx, stmt, cartID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	query := `select count(*) from "carts".approvals where cart_id = $1`
+	err = tx.QueryRowContext(ctx, query, cartID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
+
+// RecentCarts returns the most recently produced carts, newest first, optionally only those that
+// haven't been bought
+func (repo *postgresDBRepo) RecentCarts(limit int, openOnly bool) ([]models.Cart, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, event_date, event_name, event_venue, seat_info, ticket_info, ticket_price, ticket_total,
+       			quantity, bought, stock_type, user_id, coalesce(bought_by, ''), created_at, updated_at
+			from "carts".carts
+			where not (bought and $1)
+			order by created_at desc
+			limit $2`
+
+	rows, err := repo.DB.QueryContext(ctx, query, openOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var carts []models.Cart
+	for rows.Next() {
+		var c models.Cart
+		err = rows.Scan(&c.ID, &c.EventDate, &c.EventName, &c.EventVenue, &c.SeatInfo, &c.TicketInfo,
+			&c.TicketPrice, &c.TicketTotal, &c.Quantity, &c.Bought, &c.StockType, &c.UserID, &c.BoughtBy, &c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		carts = append(carts, c)
+	}
+
+	return carts, rows.Err()
+}
@@ -0,0 +1,106 @@
+package dbrepo
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// ConfirmCart saves the agent's checkout confirmation and carries the charged amounts over to the
+// cart's inventory row. Confirming the same cart again replaces the earlier confirmation.
+func (repo *postgresDBRepo) ConfirmCart(c models.Confirmation) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into "carts".confirmations (cart_id, user_id, order_number, charged_total, fees, approved_total,
+                                   discrepancy, flagged, attachment_id)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, 0))
+			on conflict (cart_id) do update set user_id = excluded.user_id, order_number = excluded.order_number,
+				charged_total = excluded.charged_total, fees = excluded.fees,
+				approved_total = excluded.approved_total, discrepancy = excluded.discrepancy,
+				flagged = excluded.flagged,
+				attachment_id = coalesce(excluded.attachment_id, "carts".confirmations.attachment_id)
+			returning id`
+
+	var newId int
+	err = tx.QueryRowContext(ctx, stmt,
+		c.CartID,
+		c.UserID,
+		c.OrderNumber,
+		c.ChargedTotal,
+		c.Fees,
+		c.ApprovedTotal,
+		c.Discrepancy,
+		c.Flagged,
+		c.AttachmentID,
+	).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	// what was actually charged is the real cost of the tickets
+	stmt = `update "inventory".inventory set cost_basis = $1, fees = $2 where cart_id = $3`
+	_, err = tx.ExecContext(ctx, stmt, c.ChargedTotal-c.Fees, c.Fees, c.CartID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, tx.Commit()
+}
+
+// AllConfirmations returns the most recent confirmations, optionally only the flagged ones
+func (repo *postgresDBRepo) AllConfirmations(limit int, flaggedOnly bool) ([]models.Confirmation, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select f.id, f.cart_id, f.user_id, coalesce(c.event_name, ''), f.order_number, f.charged_total, f.fees,
+				f.approved_total, f.discrepancy, f.flagged, coalesce(f.attachment_id, 0), f.created_at, f.updated_at
+			from "carts".confirmations f
+			left join "carts".carts c on c.id = f.cart_id
+			where f.flagged or not $1
+			order by f.created_at desc
+			limit $2`
+
+	rows, err := repo.DB.QueryContext(ctx, query, flaggedOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var confirmations []models.Confirmation
+
+	for rows.Next() {
+		var c models.Confirmation
+		err = rows.Scan(
+			&c.ID,
+			&c.CartID,
+			&c.UserID,
+			&c.EventName,
+			&c.OrderNumber,
+			&c.ChargedTotal,
+			&c.Fees,
+			&c.ApprovedTotal,
+			&c.Discrepancy,
+			&c.Flagged,
+			&c.AttachmentID,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		confirmations = append(confirmations, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return confirmations, nil
+}
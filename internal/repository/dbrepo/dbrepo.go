@@ -1,6 +1,7 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
 	"github.com/SeatSnobAri/seatflipsite/internal/config"
 	"github.com/SeatSnobAri/seatflipsite/internal/repository"
@@ -11,6 +12,7 @@ var app *config.AppConfig
 type postgresDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+	ctx context.Context
 }
 type testDBRepo struct {
 	App *config.AppConfig
@@ -26,6 +28,23 @@ func NewPostgresRepo(Conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo
 	}
 }
 
+// WithContext returns a copy of the repository whose queries carry the values of ctx, so they are
+// traced as part of the request. They aren't cancelled with ctx: a query that has started finishes
+// even if the client goes away.
+func (repo *postgresDBRepo) WithContext(ctx context.Context) repository.DatabaseRepo {
+	r := *repo
+	r.ctx = context.WithoutCancel(ctx)
+	return &r
+}
+
+// baseContext is what the timeout of every query starts from
+func (repo *postgresDBRepo) baseContext() context.Context {
+	if repo.ctx == nil {
+		return context.Background()
+	}
+	return repo.ctx
+}
+
 // NewTestingRepo creates a repo with a dummy database for testing
 //func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 //	return &testDBRepo{
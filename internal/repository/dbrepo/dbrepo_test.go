@@ -0,0 +1,72 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/migrate"
+	"github.com/SeatSnobAri/seatflipsite/migrations"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDatabaseEnv names the variable holding the url of a Postgres server the tests may create
+// databases on. Without it the database tests are skipped.
+const testDatabaseEnv = "SEATFLIP_TEST_DATABASE_URL"
+
+// newTestRepo returns a repository on a new, migrated database that is dropped when the test ends
+func newTestRepo(t *testing.T) *postgresDBRepo {
+	t.Helper()
+
+	dsn := os.Getenv(testDatabaseEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := stdlib.OpenDB(*config)
+	t.Cleanup(func() { server.Close() })
+
+	name := fmt.Sprintf("seatflip_test_%d", time.Now().UnixNano())
+	if _, err = server.Exec(`create database ` + name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := server.Exec(`drop database if exists ` + name + ` with (force)`); err != nil {
+			t.Error(err)
+		}
+	})
+
+	config.Database = name
+	// periods are cut in UTC whatever the server's time zone
+	config.RuntimeParams["timezone"] = "UTC"
+	db := stdlib.OpenDB(*config)
+	t.Cleanup(func() { db.Close() })
+
+	runner, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = runner.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return &postgresDBRepo{DB: db}
+}
+
+// seed runs each statement against db
+func seed(t *testing.T, db *sql.DB, statements ...string) {
+	t.Helper()
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("seed %q: %v", stmt, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package dbrepo
+
+import (
+	"context"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"strings"
+)
+
+// exportWhere builds the where clause shared by the export queries. table is the alias of the
+// table being exported, and stateColumn the expression its state filter applies to.
+func exportWhere(f models.ExportFilter, table, stateColumn string) (string, []any) {
+	var where []string
+	var args []any
+
+	add := func(clause string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+
+	if !f.From.IsZero() {
+		add(table+".created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add(table+".created_at < $%d", f.To)
+	}
+	if f.State != "" {
+		add(stateColumn+" = $%d", f.State)
+	}
+	if f.Event != "" {
+		add(table+".event_name ilike '%%' || $%d || '%%'", f.Event)
+	}
+	if f.Agent != "" {
+		add(table+".user_id = $%d", f.Agent)
+	}
+	if f.Team != "" {
+		add("u.team = $%d", f.Team)
+	}
+
+	if len(where) == 0 {
+		return "", args
+	}
+	return "where " + strings.Join(where, " and "), args
+}
+
+// ExportCarts streams carts matching the filter to fn, one row at a time
+func (repo *postgresDBRepo) ExportCarts(ctx context.Context, f models.ExportFilter, fn func(models.Cart) error) error {
+	where, args := exportWhere(f, "c", "case when c.bought then 'bought' else 'open' end")
+
+	query := `select c.id, c.event_date, c.event_name, c.event_venue, c.seat_info, c.ticket_info, c.ticket_price,
+       			c.ticket_total, c.quantity, c.bought, c.stock_type, c.user_id, c.created_at, c.updated_at
+			from "carts".carts c
+				left join "users".users u on u.id = c.user_id
+			` + where + `
+			order by c.created_at`
+
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.Cart
+		err = rows.Scan(
+			&c.ID,
+			&c.EventDate,
+			&c.EventName,
+			&c.EventVenue,
+			&c.SeatInfo,
+			&c.TicketInfo,
+			&c.TicketPrice,
+			&c.TicketTotal,
+			&c.Quantity,
+			&c.Bought,
+			&c.StockType,
+			&c.UserID,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err = fn(c); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ExportInventory streams purchased inventory matching the filter to fn, one row at a time
+func (repo *postgresDBRepo) ExportInventory(ctx context.Context, f models.ExportFilter, fn func(models.InventoryItem) error) error {
+	where, args := exportWhere(f, "i", "i.state")
+
+	query := `select i.id, i.cart_id, i.user_id, i.event_date, i.event_name, i.event_venue, i.seat_info, i.state,
+       			i.quantity, i.cost_basis, i.fees, i.listing_price, i.sale_price, i.created_at, i.updated_at
+			from "inventory".inventory i
+				left join "users".users u on u.id = i.user_id
+			` + where + `
+			order by i.created_at`
+
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.InventoryItem
+		err = rows.Scan(
+			&i.ID,
+			&i.CartID,
+			&i.UserID,
+			&i.EventDate,
+			&i.EventName,
+			&i.EventVenue,
+			&i.SeatInfo,
+			&i.State,
+			&i.Quantity,
+			&i.CostBasis,
+			&i.Fees,
+			&i.ListingPrice,
+			&i.SalePrice,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		if err = fn(i); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,110 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// CartExists reports whether a cart with id has already been stored
+func (repo *postgresDBRepo) CartExists(id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	query := `select exists(select 1 from "carts".carts where id = $1)`
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// ImportPurchases stores historical bought carts and their inventory rows in one transaction, so a
+// failure part way through imports none of them. It returns how many were stored; carts that were
+// already imported are left alone.
+func (repo *postgresDBRepo) ImportPurchases(purchases []models.ImportedPurchase) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 30*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for _, p := range purchases {
+		ok, err := importPurchase(ctx, tx, p.Cart, p.Item)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %w", p.Line, err)
+		}
+		if ok {
+			imported++
+		}
+	}
+
+	return imported, tx.Commit()
+}
+
+// importPurchase stores a bought cart and its inventory row in tx. It returns false, without
+// error, when the cart was already imported.
+func importPurchase(ctx context.Context, tx *sql.Tx, c models.Cart, i models.InventoryItem) (bool, error) {
+	stmt := `insert into "carts".carts (id, event_date, event_name, event_venue, seat_info, ticket_info, ticket_price,
+                         ticket_total, quantity, bought, stock_type, user_id, created_at, updated_at)
+			values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$13)
+			on conflict (id) do nothing`
+
+	res, err := tx.ExecContext(ctx, stmt,
+		c.ID,
+		c.EventDate,
+		c.EventName,
+		c.EventVenue,
+		c.SeatInfo,
+		c.TicketInfo,
+		c.TicketPrice,
+		c.TicketTotal,
+		c.Quantity,
+		true,
+		c.StockType,
+		c.UserID,
+		c.CreatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	stmt = `insert into "inventory".inventory (cart_id, user_id, event_date, event_name, event_venue, seat_info,
+                                   state, quantity, cost_basis, fees, listing_price, sale_price, created_at, updated_at)
+			values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$13)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		c.ID,
+		c.UserID,
+		c.EventDate,
+		c.EventName,
+		c.EventVenue,
+		c.SeatInfo,
+		i.State,
+		i.Quantity,
+		i.CostBasis,
+		i.Fees,
+		i.ListingPrice,
+		i.SalePrice,
+		c.CreatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
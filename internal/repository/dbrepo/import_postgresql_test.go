@@ -0,0 +1,75 @@
+package dbrepo
+
+import (
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"testing"
+	"time"
+)
+
+// purchase builds an imported purchase of quantity tickets for ann
+func purchase(line int, id string, quantity int) models.ImportedPurchase {
+	return models.ImportedPurchase{
+		Line: line,
+		Cart: models.Cart{
+			ID:          id,
+			EventName:   "Show A",
+			EventDate:   "2023-08-01",
+			TicketTotal: 100,
+			Quantity:    quantity,
+			UserID:      "ann",
+			CreatedAt:   time.Date(2023, 7, 3, 10, 0, 0, 0, time.UTC),
+		},
+		Item: models.InventoryItem{
+			CartID:    id,
+			State:     models.InventoryPurchased,
+			Quantity:  quantity,
+			CostBasis: 100,
+		},
+	}
+}
+
+// count returns the number of rows in table
+func count(t *testing.T, repo *postgresDBRepo, table string) int {
+	t.Helper()
+
+	var n int
+	if err := repo.DB.QueryRow(`select count(*) from ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestImportPurchasesSkipsImported(t *testing.T) {
+	repo := newTestRepo(t)
+	seed(t, repo.DB, `insert into "users".users (id, first_name, last_name) values ('ann', 'Ann', 'Agent')`)
+
+	imported, err := repo.ImportPurchases([]models.ImportedPurchase{purchase(2, "c1", 2)})
+	if err != nil || imported != 1 {
+		t.Fatalf("first import = %d, %v; want 1, nil", imported, err)
+	}
+
+	imported, err = repo.ImportPurchases([]models.ImportedPurchase{purchase(2, "c1", 2), purchase(3, "c2", 1)})
+	if err != nil || imported != 1 {
+		t.Fatalf("second import = %d, %v; want 1, nil", imported, err)
+	}
+	if n := count(t, repo, `"inventory".inventory`); n != 2 {
+		t.Errorf("inventory has %d rows, want 2", n)
+	}
+}
+
+func TestImportPurchasesAllOrNothing(t *testing.T) {
+	repo := newTestRepo(t)
+	seed(t, repo.DB, `insert into "users".users (id, first_name, last_name) values ('ann', 'Ann', 'Agent')`)
+
+	// the second row breaks the quantity check, so the first mustn't be kept
+	_, err := repo.ImportPurchases([]models.ImportedPurchase{purchase(2, "c1", 2), purchase(3, "c2", 0)})
+	if err == nil {
+		t.Fatal("import succeeded, want an error")
+	}
+	if n := count(t, repo, `"carts".carts`); n != 0 {
+		t.Errorf("carts has %d rows, want 0", n)
+	}
+	if n := count(t, repo, `"inventory".inventory`); n != 0 {
+		t.Errorf("inventory has %d rows, want 0", n)
+	}
+}
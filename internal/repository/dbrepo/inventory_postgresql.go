@@ -0,0 +1,137 @@
+package dbrepo
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// InsertInventoryFromCart creates the inventory row for a bought cart
+func (repo *postgresDBRepo) InsertInventoryFromCart(cart models.Cart) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "inventory".inventory (cart_id, user_id, event_date, event_name, event_venue, seat_info,
+                                   state, quantity, cost_basis)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			on conflict (cart_id) do update set updated_at = now()
+			returning id`
+
+	var newId int
+	err := repo.DB.QueryRowContext(ctx, stmt,
+		cart.ID,
+		cart.UserID,
+		cart.EventDate,
+		cart.EventName,
+		cart.EventVenue,
+		cart.SeatInfo,
+		models.InventoryPurchased,
+		cart.Quantity,
+		cart.TicketTotal,
+	).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// AllInventory returns all inventory items, newest first
+func (repo *postgresDBRepo) AllInventory() ([]*models.InventoryItem, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, cart_id, user_id, event_date, event_name, event_venue, seat_info, state, quantity,
+       			cost_basis, fees, listing_price, sale_price, created_at, updated_at
+			from "inventory".inventory order by created_at desc`
+
+	rows, err := repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*models.InventoryItem
+
+	for rows.Next() {
+		i := &models.InventoryItem{}
+		err = rows.Scan(
+			&i.ID,
+			&i.CartID,
+			&i.UserID,
+			&i.EventDate,
+			&i.EventName,
+			&i.EventVenue,
+			&i.SeatInfo,
+			&i.State,
+			&i.Quantity,
+			&i.CostBasis,
+			&i.Fees,
+			&i.ListingPrice,
+			&i.SalePrice,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetInventoryById returns an inventory item by id
+func (repo *postgresDBRepo) GetInventoryById(id int) (models.InventoryItem, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, cart_id, user_id, event_date, event_name, event_venue, seat_info, state, quantity,
+       			cost_basis, fees, listing_price, sale_price, created_at, updated_at
+			from "inventory".inventory where id = $1`
+
+	var i models.InventoryItem
+	row := repo.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&i.ID,
+		&i.CartID,
+		&i.UserID,
+		&i.EventDate,
+		&i.EventName,
+		&i.EventVenue,
+		&i.SeatInfo,
+		&i.State,
+		&i.Quantity,
+		&i.CostBasis,
+		&i.Fees,
+		&i.ListingPrice,
+		&i.SalePrice,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	if err != nil {
+		return i, err
+	}
+
+	return i, nil
+}
+
+// UpdateInventory saves the state and pricing of an inventory item
+func (repo *postgresDBRepo) UpdateInventory(i models.InventoryItem) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "inventory".inventory set state = $1, fees = $2, listing_price = $3, sale_price = $4
+			where id = $5`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, i.State, i.Fees, i.ListingPrice, i.SalePrice, i.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,246 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/jackc/pgtype"
+	"time"
+)
+
+// defaultMaxAttempts matches the column default, for jobs queued without a limit
+const defaultMaxAttempts = 5
+
+const jobColumns = `id, kind, payload, status, attempts, max_attempts, run_at, last_error, result, user_id,
+			finished_at, created_at, updated_at`
+
+// scanJob reads a row selected with jobColumns
+func scanJob(row interface{ Scan(dest ...any) error }) (models.Job, error) {
+	var j models.Job
+	var payload, result []byte
+	var finishedAt sql.NullTime
+
+	err := row.Scan(&j.ID, &j.Kind, &payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.RunAt, &j.LastError,
+		&result, &j.UserID, &finishedAt, &j.CreatedAt, &j.UpdatedAt)
+	if err != nil {
+		return j, err
+	}
+	j.Payload = payload
+	j.Result = result
+	if finishedAt.Valid {
+		j.FinishedAt = finishedAt.Time
+	}
+	return j, nil
+}
+
+// insertJob queues a job using q, which is the pool or a transaction
+func insertJob(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, j models.Job) (int64, error) {
+	if len(j.Payload) == 0 {
+		j.Payload = []byte("{}")
+	}
+	if j.MaxAttempts <= 0 {
+		j.MaxAttempts = defaultMaxAttempts
+	}
+	if j.RunAt.IsZero() {
+		j.RunAt = time.Now()
+	}
+
+	stmt := `insert into "jobs".jobs (kind, payload, max_attempts, run_at, user_id)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	var id int64
+	err := q.QueryRowContext(ctx, stmt, j.Kind, string(j.Payload), j.MaxAttempts, j.RunAt, j.UserID).Scan(&id)
+	return id, err
+}
+
+// EnqueueJob queues a job and returns its id
+func (repo *postgresDBRepo) EnqueueJob(j models.Job) (int64, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	return insertJob(ctx, repo.DB, j)
+}
+
+// ClaimJob marks the oldest due job of one of kinds as running and returns it. Jobs that have been
+// running for longer than stale are assumed to belong to a worker that died and are claimed again.
+// ok is false when there is nothing to do.
+func (repo *postgresDBRepo) ClaimJob(kinds []string, stale time.Duration) (models.Job, bool, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	var k pgtype.TextArray
+	if err := k.Set(kinds); err != nil {
+		return models.Job{}, false, err
+	}
+
+	query := `update "jobs".jobs set status = 'running', attempts = attempts + 1, locked_at = now()
+			where id = (
+				select id from "jobs".jobs
+				where kind = any($1) and run_at <= now()
+					and (status = 'queued' or (status = 'running' and locked_at < $2))
+				order by run_at, id
+				limit 1
+				for update skip locked)
+			returning ` + jobColumns
+
+	j, err := scanJob(repo.DB.QueryRowContext(ctx, query, &k, time.Now().Add(-stale)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return j, false, nil
+	}
+	if err != nil {
+		return j, false, err
+	}
+	return j, true, nil
+}
+
+// CompleteJob marks a running job as done and stores its result
+func (repo *postgresDBRepo) CompleteJob(id int64, result []byte) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	var r any
+	if len(result) > 0 {
+		r = string(result)
+	}
+
+	stmt := `update "jobs".jobs set status = 'done', result = $1, last_error = '', locked_at = null,
+			finished_at = now() where id = $2`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, r, id)
+	return err
+}
+
+// RetryJob records a failed attempt and queues the job to run again at runAt
+func (repo *postgresDBRepo) RetryJob(id int64, lastError string, runAt time.Time) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "jobs".jobs set status = 'queued', last_error = $1, run_at = $2, locked_at = null
+			where id = $3`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, lastError, runAt, id)
+	return err
+}
+
+// FailJob records a failed attempt and gives up on the job
+func (repo *postgresDBRepo) FailJob(id int64, lastError string) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "jobs".jobs set status = 'failed', last_error = $1, locked_at = null, finished_at = now()
+			where id = $2`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, lastError, id)
+	return err
+}
+
+// GetJobById returns one job
+func (repo *postgresDBRepo) GetJobById(id int64) (models.Job, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + jobColumns + ` from "jobs".jobs where id = $1`
+
+	return scanJob(repo.DB.QueryRowContext(ctx, query, id))
+}
+
+// RecentJobs returns the most recently queued jobs, newest first
+func (repo *postgresDBRepo) RecentJobs(limit int) ([]models.Job, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + jobColumns + ` from "jobs".jobs order by created_at desc, id desc limit $1`
+
+	rows, err := repo.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// ScheduleJob queues j if the schedule called name is due, and moves the schedule on to next. A
+// schedule seen for the first time is not due until next. Only one caller wins each run, so every
+// instance can check the same schedules.
+func (repo *postgresDBRepo) ScheduleJob(name string, next time.Time, j models.Job) (bool, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `insert into "jobs".schedules (name, next_run_at) values ($1, $2)
+			on conflict (name) do nothing`, name, next)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.ExecContext(ctx, `update "jobs".schedules set next_run_at = $1, last_run_at = now()
+			where name = $2 and next_run_at <= now()`, next, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err = insertJob(ctx, tx, j); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// PruneJobs deletes finished jobs older than before and returns how many were deleted
+func (repo *postgresDBRepo) PruneJobs(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 30*time.Second)
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `delete from "jobs".jobs where status in ('done', 'failed')
+			and finished_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PruneOutbox deletes dispatched outbox messages older than before and returns how many were deleted
+func (repo *postgresDBRepo) PruneOutbox(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 30*time.Second)
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `delete from "outbox".messages where dispatched_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpiredSessions removes expired sessions and returns how many were removed
+func (repo *postgresDBRepo) DeleteExpiredSessions() (int64, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 30*time.Second)
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `delete from sessions where expiry < current_timestamp`)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
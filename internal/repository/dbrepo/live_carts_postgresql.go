@@ -0,0 +1,140 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v4/stdlib"
+	"time"
+)
+
+// liveCartChannel is the channel expired live carts are announced on
+const liveCartChannel = "live_carts_expired"
+
+// PutLiveCart holds a cart until expiresAt
+func (repo *postgresDBRepo) PutLiveCart(id string, value []byte, expiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "carts".live (id, value, expires_at) values ($1, $2, $3)
+			on conflict (id) do update set value = excluded.value, expires_at = excluded.expires_at`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, id, string(value), expiresAt)
+	return err
+}
+
+// GetLiveCart returns a held cart, reporting false if it isn't held or its hold has run out
+func (repo *postgresDBRepo) GetLiveCart(id string) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select value from "carts".live where id = $1 and expires_at > now()`
+
+	var value string
+	err := repo.DB.QueryRowContext(ctx, query, id).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(value), true, nil
+}
+
+// UpdateLiveCart replaces a held cart, keeping its expiry
+func (repo *postgresDBRepo) UpdateLiveCart(id string, value []byte) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "carts".live set value = $2 where id = $1 and expires_at > now()`
+
+	_, err := repo.DB.ExecContext(ctx, stmt, id, string(value))
+	return err
+}
+
+// LiveCarts returns every held cart, oldest first
+func (repo *postgresDBRepo) LiveCarts() ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select value from "carts".live where expires_at > now() order by expires_at`
+
+	rows, err := repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values [][]byte
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, []byte(value))
+	}
+
+	return values, rows.Err()
+}
+
+// ExpireLiveCartAt moves a held cart's expiry to at, reporting false if it isn't held
+func (repo *postgresDBRepo) ExpireLiveCartAt(id string, at time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "carts".live set expires_at = $2 where id = $1 and expires_at > now()`
+
+	res, err := repo.DB.ExecContext(ctx, stmt, id, at)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SweepLiveCarts deletes the carts whose holds have run out and announces each on liveCartChannel.
+// The notifications are sent when the delete commits, so a cart is announced exactly once however
+// many instances sweep.
+func (repo *postgresDBRepo) SweepLiveCarts() (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `with expired as (
+				delete from "carts".live where expires_at <= now() returning id)
+			select pg_notify($1, id) from expired`
+
+	res, err := repo.DB.ExecContext(ctx, stmt, liveCartChannel)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ListenLiveCartExpiries calls expired with the id of every cart announced on liveCartChannel
+// until ctx is done or the connection fails. listening is called once the listen has taken
+// effect. It holds one connection of the pool for as long as it runs.
+func (repo *postgresDBRepo) ListenLiveCartExpiries(ctx context.Context, listening func(), expired func(id string)) error {
+	conn, err := repo.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		c := driverConn.(*stdlib.Conn).Conn()
+
+		if _, err := c.Exec(ctx, "listen "+liveCartChannel); err != nil {
+			return err
+		}
+		listening()
+
+		for {
+			n, err := c.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+			expired(n.Payload)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+const snipeOrderColumns = `id, user_id, event_name, venue, sections, max_price, quantity, auto_approve, notes, status,
+	coalesce(fulfilled_cart_id, ''), expires_at, created_at, updated_at`
+
+// InsertSnipeOrder adds an open snipe order
+func (repo *postgresDBRepo) InsertSnipeOrder(o models.SnipeOrder) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "orders".snipe_orders (user_id, event_name, venue, sections, max_price, quantity,
+                                   auto_approve, notes, expires_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	var newId int
+	err := repo.DB.QueryRowContext(ctx, stmt,
+		o.UserID,
+		o.EventName,
+		o.Venue,
+		o.Sections,
+		o.MaxPrice,
+		o.Quantity,
+		o.AutoApprove,
+		o.Notes,
+		o.ExpiresAt,
+	).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// UpdateSnipeOrder changes what an open snipe order is looking for
+func (repo *postgresDBRepo) UpdateSnipeOrder(o models.SnipeOrder) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "orders".snipe_orders set event_name = $1, venue = $2, sections = $3, max_price = $4,
+                quantity = $5, auto_approve = $6, notes = $7, expires_at = $8
+			where id = $9 and status = 'open'`
+
+	_, err := repo.DB.ExecContext(ctx, stmt,
+		o.EventName,
+		o.Venue,
+		o.Sections,
+		o.MaxPrice,
+		o.Quantity,
+		o.AutoApprove,
+		o.Notes,
+		o.ExpiresAt,
+		o.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetSnipeOrderById returns a snipe order by id
+func (repo *postgresDBRepo) GetSnipeOrderById(id int) (models.SnipeOrder, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + snipeOrderColumns + ` from "orders".snipe_orders where id = $1`
+
+	orders, err := scanSnipeOrders(ctx, repo.DB, query, id)
+	if err != nil {
+		return models.SnipeOrder{}, err
+	}
+	if len(orders) == 0 {
+		return models.SnipeOrder{}, sql.ErrNoRows
+	}
+	return orders[0], nil
+}
+
+// AllSnipeOrders returns the most recent snipe orders, open ones first
+func (repo *postgresDBRepo) AllSnipeOrders(limit int) ([]models.SnipeOrder, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + snipeOrderColumns + ` from "orders".snipe_orders
+			order by status = 'open' desc, created_at desc limit $1`
+
+	return scanSnipeOrders(ctx, repo.DB, query, limit)
+}
+
+// OpenSnipeOrders returns the orders agents can still fulfil, oldest first
+func (repo *postgresDBRepo) OpenSnipeOrders() ([]models.SnipeOrder, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + snipeOrderColumns + ` from "orders".snipe_orders
+			where status = 'open' and expires_at > now()
+			order by created_at`
+
+	return scanSnipeOrders(ctx, repo.DB, query)
+}
+
+// SetSnipeOrderStatus closes an open snipe order with status. An order that is already closed keeps
+// its status, and models.ErrOrderNotOpen is returned.
+func (repo *postgresDBRepo) SetSnipeOrderStatus(id int, status string) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update "orders".snipe_orders set status = $1 where id = $2 and status = 'open'`
+	res, err := repo.DB.ExecContext(ctx, stmt, status, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrOrderNotOpen
+	}
+	return nil
+}
+
+// ExpireSnipeOrders closes open orders that are past their expiry and returns their ids
+func (repo *postgresDBRepo) ExpireSnipeOrders() ([]int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `update "orders".snipe_orders set status = 'expired'
+			where status = 'open' and expires_at <= now() returning id`
+
+	rows, err := repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// TagCartWithOrder records that a cart matched a snipe order
+func (repo *postgresDBRepo) TagCartWithOrder(cartID string, orderID int) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `update "carts".carts set snipe_order_id = $1 where id = $2`, orderID, cartID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func scanSnipeOrders(ctx context.Context, q queryer, query string, args ...any) ([]models.SnipeOrder, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.SnipeOrder
+
+	for rows.Next() {
+		var o models.SnipeOrder
+		err = rows.Scan(
+			&o.ID,
+			&o.UserID,
+			&o.EventName,
+			&o.Venue,
+			&o.Sections,
+			&o.MaxPrice,
+			&o.Quantity,
+			&o.AutoApprove,
+			&o.Notes,
+			&o.Status,
+			&o.FulfilledCartID,
+			&o.ExpiresAt,
+			&o.CreatedAt,
+			&o.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,107 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// insertOutbox writes outbox messages inside the caller's transaction
+func insertOutbox(ctx context.Context, tx *sql.Tx, msgs []models.OutboxMessage) error {
+	stmt := `insert into "outbox".messages (kind, key, payload) values ($1, $2, $3)`
+
+	for _, m := range msgs {
+		_, err := tx.ExecContext(ctx, stmt, m.Kind, m.Key, string(m.Payload))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DispatchOutbox hands up to limit due messages to fn, oldest first, and records the outcome. A
+// message waits while an earlier message with the same key is still pending, so a cart's broadcast
+// never goes out before its redis entry is written. When fn fails, backoff says how long to wait
+// before the next attempt, or that the message should be given up on. Messages are locked while
+// they are dispatched, so several dispatchers can run at once. It returns how many were dispatched.
+func (repo *postgresDBRepo) DispatchOutbox(limit int, fn func(models.OutboxMessage) error,
+	backoff func(attempts int) (time.Duration, bool)) (int, error) {
+	// fn talks to redis and pusher, so this gets longer than the usual query timeout
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 30*time.Second)
+	defer cancel()
+
+	tx, err := repo.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `select m.id, m.kind, m.key, m.payload, m.attempts, m.last_error, m.next_attempt_at, m.created_at
+			from "outbox".messages m
+			where m.dispatched_at is null and m.failed_at is null and m.next_attempt_at <= now()
+				and not exists (
+					select 1 from "outbox".messages p
+					where p.key = m.key and p.id < m.id and p.dispatched_at is null and p.failed_at is null)
+			order by m.id
+			limit $1
+			for update skip locked`
+
+	rows, err := tx.QueryContext(ctx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	var msgs []models.OutboxMessage
+	for rows.Next() {
+		var m models.OutboxMessage
+		var payload []byte
+		err = rows.Scan(&m.ID, &m.Kind, &m.Key, &payload, &m.Attempts, &m.LastError, &m.NextAttemptAt, &m.CreatedAt)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		m.Payload = payload
+		msgs = append(msgs, m)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	dispatched := 0
+	blocked := make(map[string]bool)
+
+	for _, m := range msgs {
+		// an earlier message for this key failed in this batch, so this one waits its turn
+		if blocked[m.Key] {
+			continue
+		}
+
+		err = fn(m)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `update "outbox".messages set dispatched_at = now(), attempts = attempts + 1
+				where id = $1`, m.ID)
+			if err != nil {
+				return dispatched, err
+			}
+			dispatched++
+			continue
+		}
+
+		blocked[m.Key] = true
+		wait, retry := backoff(m.Attempts + 1)
+		if retry {
+			_, err = tx.ExecContext(ctx, `update "outbox".messages set attempts = attempts + 1, last_error = $1,
+				next_attempt_at = $2 where id = $3`, err.Error(), time.Now().Add(wait), m.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, `update "outbox".messages set attempts = attempts + 1, last_error = $1,
+				failed_at = now() where id = $2`, err.Error(), m.ID)
+		}
+		if err != nil {
+			return dispatched, err
+		}
+	}
+
+	return dispatched, tx.Commit()
+}
@@ -0,0 +1,190 @@
+package dbrepo
+
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// pnlColumns computes cost, fees, revenue, realized and unrealized profit and loss over inventory rows.
+// Sold, transferred and delivered items are realized at their sale price; purchased and listed items
+// are marked at their listing price, or at cost when they have not been listed yet. Refunded items
+// are excluded by the callers.
+const pnlColumns = `
+	coalesce(sum(i.cost_basis), 0) as cost,
+	coalesce(sum(i.fees), 0) as fees,
+	coalesce(sum(case when i.state in ('sold', 'transferred', 'delivered') then i.sale_price else 0 end), 0) as revenue,
+	coalesce(sum(case when i.state in ('sold', 'transferred', 'delivered')
+		then i.sale_price - i.cost_basis - i.fees else 0 end), 0) as realized,
+	coalesce(sum(case when i.state in ('purchased', 'listed')
+		then (case when i.listing_price > 0 then i.listing_price else i.cost_basis end) - i.cost_basis - i.fees
+		else 0 end), 0) as unrealized`
+
+// EventReport returns profit and loss per event
+func (repo *postgresDBRepo) EventReport(f models.ReportFilter) ([]models.EventReport, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 10*time.Second)
+	defer cancel()
+
+	query := `select i.event_name, i.event_date, count(*), coalesce(sum(i.quantity), 0),` + pnlColumns + `
+		from "inventory".inventory i
+		where i.state <> 'refunded' and i.created_at >= $1 and i.created_at < $2
+		group by i.event_name, i.event_date
+		order by i.event_date, i.event_name`
+
+	rows, err := repo.DB.QueryContext(ctx, query, f.From, f.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []models.EventReport
+
+	for rows.Next() {
+		var e models.EventReport
+		err = rows.Scan(
+			&e.EventName,
+			&e.EventDate,
+			&e.Items,
+			&e.Quantity,
+			&e.Cost,
+			&e.Fees,
+			&e.Revenue,
+			&e.RealizedPnL,
+			&e.UnrealizedPnL,
+		)
+		if err != nil {
+			return nil, err
+		}
+		e.ROI = models.ReturnOnInvestment(e.RealizedPnL+e.UnrealizedPnL, e.Cost+e.Fees)
+		reports = append(reports, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// AgentReport returns spend and profit and loss per agent
+func (repo *postgresDBRepo) AgentReport(f models.ReportFilter) ([]models.SpendReport, error) {
+	query := `
+		with spend as (
+			select c.user_id, count(*) as carts, coalesce(sum(c.ticket_total), 0) as spend
+			from "carts".carts c
+			where c.bought and c.created_at >= $1 and c.created_at < $2
+			group by c.user_id
+		), pnl as (
+			select i.user_id,` + pnlColumns + `
+			from "inventory".inventory i
+			where i.state <> 'refunded' and i.created_at >= $1 and i.created_at < $2
+			group by i.user_id
+		)
+		select coalesce(s.user_id, p.user_id),
+			coalesce(u.first_name || ' ' || u.last_name, ''),
+			coalesce(u.team, ''),
+			coalesce(s.carts, 0), coalesce(s.spend, 0),
+			coalesce(p.realized, 0), coalesce(p.unrealized, 0), coalesce(p.cost + p.fees, 0)
+		from spend s
+			full join pnl p on p.user_id = s.user_id
+			left join "users".users u on u.id = coalesce(s.user_id, p.user_id)
+		order by 5 desc`
+
+	return repo.spendReport(query, f.From, f.To)
+}
+
+// TeamReport returns spend and profit and loss per team
+func (repo *postgresDBRepo) TeamReport(f models.ReportFilter) ([]models.SpendReport, error) {
+	query := `
+		with spend as (
+			select coalesce(u.team, '') as team, count(*) as carts, coalesce(sum(c.ticket_total), 0) as spend
+			from "carts".carts c
+				left join "users".users u on u.id = c.user_id
+			where c.bought and c.created_at >= $1 and c.created_at < $2
+			group by 1
+		), pnl as (
+			select coalesce(u.team, '') as team,` + pnlColumns + `
+			from "inventory".inventory i
+				left join "users".users u on u.id = i.user_id
+			where i.state <> 'refunded' and i.created_at >= $1 and i.created_at < $2
+			group by 1
+		)
+		select coalesce(s.team, p.team), '', coalesce(s.team, p.team),
+			coalesce(s.carts, 0), coalesce(s.spend, 0),
+			coalesce(p.realized, 0), coalesce(p.unrealized, 0), coalesce(p.cost + p.fees, 0)
+		from spend s
+			full join pnl p on p.team = s.team
+		order by 5 desc`
+
+	return repo.spendReport(query, f.From, f.To)
+}
+
+// PeriodReport returns spend and profit and loss per day, week or month. Every period from the first
+// one with any activity up to the end of the filter is listed, those without activity with zeros.
+func (repo *postgresDBRepo) PeriodReport(f models.ReportFilter) ([]models.SpendReport, error) {
+	query := `
+		with spend as (
+			select date_trunc($3, c.created_at) as period, count(*) as carts, coalesce(sum(c.ticket_total), 0) as spend
+			from "carts".carts c
+			where c.bought and c.created_at >= $1 and c.created_at < $2
+			group by 1
+		), pnl as (
+			select date_trunc($3, i.created_at) as period,` + pnlColumns + `
+			from "inventory".inventory i
+			where i.state <> 'refunded' and i.created_at >= $1 and i.created_at < $2
+			group by 1
+		), periods as (
+			select generate_series(b.first, $2::timestamptz - interval '1 microsecond', ('1 ' || $3)::interval) as period
+			from (select min(period) as first from (select period from spend union all select period from pnl) a) b
+			where b.first is not null
+		)
+		select to_char(d.period, 'YYYY-MM-DD'), '', '',
+			coalesce(s.carts, 0), coalesce(s.spend, 0),
+			coalesce(p.realized, 0), coalesce(p.unrealized, 0), coalesce(p.cost + p.fees, 0)
+		from periods d
+			left join spend s on s.period = d.period
+			left join pnl p on p.period = d.period
+		order by 1`
+
+	return repo.spendReport(query, f.From, f.To, f.Period)
+}
+
+// spendReport runs a spend report query and scans its rows
+func (repo *postgresDBRepo) spendReport(query string, args ...any) ([]models.SpendReport, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 10*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []models.SpendReport
+
+	for rows.Next() {
+		var s models.SpendReport
+		var cost float64
+		err = rows.Scan(
+			&s.Key,
+			&s.Name,
+			&s.Team,
+			&s.Carts,
+			&s.Spend,
+			&s.RealizedPnL,
+			&s.UnrealizedPnL,
+			&cost,
+		)
+		if err != nil {
+			return nil, err
+		}
+		s.ROI = models.ReturnOnInvestment(s.RealizedPnL+s.UnrealizedPnL, cost)
+		reports = append(reports, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
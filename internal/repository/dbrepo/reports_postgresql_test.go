@@ -0,0 +1,171 @@
+package dbrepo
+
+import (
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// seedReports adds two agents on different teams, their carts and the inventory bought with them.
+// In the week of 2023-07-03 Ann buys for Show A on the 3rd, selling one lot and listing the other,
+// and Bob buys for Show B on the 5th and the 6th; the lot from the 6th is refunded. Bob's cart on
+// the 5th that wasn't bought, and Ann's purchase the week after, fall outside the reports.
+func seedReports(t *testing.T, repo *postgresDBRepo) {
+	seed(t, repo.DB,
+		`insert into "users".users (id, first_name, last_name, team) values
+			('ann', 'Ann', 'Agent', 'east'),
+			('bob', 'Bob', 'Agent', 'west')`,
+		`insert into "carts".carts (id, event_name, event_date, ticket_total, quantity, bought, user_id, created_at) values
+			('c1', 'Show A', '2023-08-01', 100, 2, true, 'ann', '2023-07-03 10:00Z'),
+			('c2', 'Show A', '2023-08-01', 50, 1, true, 'ann', '2023-07-03 12:00Z'),
+			('c3', 'Show B', '2023-08-02', 200, 4, true, 'bob', '2023-07-05 09:00Z'),
+			('c4', 'Show B', '2023-08-02', 80, 2, false, 'bob', '2023-07-05 10:00Z'),
+			('c5', 'Show B', '2023-08-02', 40, 1, true, 'bob', '2023-07-06 09:00Z'),
+			('c6', 'Show A', '2023-08-01', 999, 2, true, 'ann', '2023-07-12 09:00Z')`,
+		`insert into "inventory".inventory (cart_id, user_id, event_name, event_date, state, quantity, cost_basis, fees,
+				listing_price, sale_price, created_at) values
+			('c1', 'ann', 'Show A', '2023-08-01', 'sold', 2, 100, 10, 160, 150, '2023-07-03 10:00Z'),
+			('c2', 'ann', 'Show A', '2023-08-01', 'listed', 1, 50, 5, 70, 0, '2023-07-03 12:00Z'),
+			('c3', 'bob', 'Show B', '2023-08-02', 'purchased', 4, 200, 20, 0, 0, '2023-07-05 09:00Z'),
+			('c5', 'bob', 'Show B', '2023-08-02', 'refunded', 1, 40, 0, 0, 0, '2023-07-06 09:00Z'),
+			('c6', 'ann', 'Show A', '2023-08-01', 'sold', 2, 999, 0, 0, 1000, '2023-07-12 09:00Z')`,
+	)
+}
+
+// week is the filter the tests report over
+func week(period string) models.ReportFilter {
+	return models.ReportFilter{
+		From:   time.Date(2023, 7, 3, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2023, 7, 10, 0, 0, 0, 0, time.UTC),
+		Period: period,
+	}
+}
+
+// emptyRange has no carts or inventory in it
+var emptyRange = models.ReportFilter{
+	From:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	To:     time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	Period: "day",
+}
+
+// spend builds the expected row of a spend report
+func spend(key, name, team string, carts int, spend, realized, unrealized, cost float64) models.SpendReport {
+	return models.SpendReport{
+		Key:           key,
+		Name:          name,
+		Team:          team,
+		Carts:         carts,
+		Spend:         spend,
+		RealizedPnL:   realized,
+		UnrealizedPnL: unrealized,
+		ROI:           models.ReturnOnInvestment(realized+unrealized, cost),
+	}
+}
+
+func TestEventReport(t *testing.T) {
+	repo := newTestRepo(t)
+	seedReports(t, repo)
+
+	got, err := repo.EventReport(week("day"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.EventReport{
+		{EventName: "Show A", EventDate: "2023-08-01", Items: 2, Quantity: 3, Cost: 150, Fees: 15, Revenue: 150,
+			RealizedPnL: 40, UnrealizedPnL: 15, ROI: models.ReturnOnInvestment(55, 165)},
+		{EventName: "Show B", EventDate: "2023-08-02", Items: 1, Quantity: 4, Cost: 200, Fees: 20,
+			UnrealizedPnL: -20, ROI: models.ReturnOnInvestment(-20, 220)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("EventReport() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	got, err = repo.EventReport(emptyRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("EventReport() over an empty range = %+v, want nothing", got)
+	}
+}
+
+func TestSpendReports(t *testing.T) {
+	repo := newTestRepo(t)
+	seedReports(t, repo)
+
+	tests := []struct {
+		name   string
+		report func(models.ReportFilter) ([]models.SpendReport, error)
+		filter models.ReportFilter
+		want   []models.SpendReport
+	}{
+		{
+			name:   "agent",
+			report: repo.AgentReport,
+			filter: week("day"),
+			want: []models.SpendReport{
+				spend("bob", "Bob Agent", "west", 2, 240, 0, -20, 220),
+				spend("ann", "Ann Agent", "east", 2, 150, 40, 15, 165),
+			},
+		},
+		{
+			name:   "team",
+			report: repo.TeamReport,
+			filter: week("day"),
+			want: []models.SpendReport{
+				spend("west", "", "west", 2, 240, 0, -20, 220),
+				spend("east", "", "east", 2, 150, 40, 15, 165),
+			},
+		},
+		{
+			name:   "day",
+			report: repo.PeriodReport,
+			filter: week("day"),
+			want: []models.SpendReport{
+				spend("2023-07-03", "", "", 2, 150, 40, 15, 165),
+				spend("2023-07-04", "", "", 0, 0, 0, 0, 0),
+				spend("2023-07-05", "", "", 1, 200, 0, -20, 220),
+				spend("2023-07-06", "", "", 1, 40, 0, 0, 0),
+				spend("2023-07-07", "", "", 0, 0, 0, 0, 0),
+				spend("2023-07-08", "", "", 0, 0, 0, 0, 0),
+				spend("2023-07-09", "", "", 0, 0, 0, 0, 0),
+			},
+		},
+		{
+			name:   "week",
+			report: repo.PeriodReport,
+			filter: week("week"),
+			want: []models.SpendReport{
+				spend("2023-07-03", "", "", 4, 390, 40, -5, 385),
+			},
+		},
+		{
+			name:   "agent over an empty range",
+			report: repo.AgentReport,
+			filter: emptyRange,
+		},
+		{
+			name:   "team over an empty range",
+			report: repo.TeamReport,
+			filter: emptyRange,
+		},
+		{
+			name:   "period over an empty range",
+			report: repo.PeriodReport,
+			filter: emptyRange,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.report(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
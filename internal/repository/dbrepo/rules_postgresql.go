@@ -0,0 +1,193 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"github.com/jackc/pgtype"
+	"time"
+)
+
+const ruleColumns = `id, user_id, name, priority, enabled, action, event_name, venue, sections,
+	min_price, max_price, min_quantity, max_quantity, stock_type, created_at, updated_at`
+
+// AllRules returns every rule in priority order
+func (repo *postgresDBRepo) AllRules() ([]models.Rule, error) {
+	return repo.queryRules(`select ` + ruleColumns + ` from "rules".rules order by priority, id`)
+}
+
+// EnabledRules returns the rules that run against produced carts, in priority order
+func (repo *postgresDBRepo) EnabledRules() ([]models.Rule, error) {
+	return repo.queryRules(`select ` + ruleColumns + ` from "rules".rules where enabled order by priority, id`)
+}
+
+// GetRuleById returns a rule by id
+func (repo *postgresDBRepo) GetRuleById(id int) (models.Rule, error) {
+	rules, err := repo.queryRules(`select `+ruleColumns+` from "rules".rules where id = $1`, id)
+	if err != nil {
+		return models.Rule{}, err
+	}
+	if len(rules) == 0 {
+		return models.Rule{}, sql.ErrNoRows
+	}
+	return rules[0], nil
+}
+
+func (repo *postgresDBRepo) queryRules(query string, args ...any) ([]models.Rule, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	rows, err := repo.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []models.Rule
+
+	for rows.Next() {
+		var r models.Rule
+		err = rows.Scan(
+			&r.ID,
+			&r.UserID,
+			&r.Name,
+			&r.Priority,
+			&r.Enabled,
+			&r.Action,
+			&r.EventName,
+			&r.Venue,
+			&r.Sections,
+			&r.MinPrice,
+			&r.MaxPrice,
+			&r.MinQuantity,
+			&r.MaxQuantity,
+			&r.StockType,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// InsertRule adds a rule
+func (repo *postgresDBRepo) InsertRule(r models.Rule) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "rules".rules (user_id, name, priority, enabled, action, event_name, venue, sections,
+                           min_price, max_price, min_quantity, max_quantity, stock_type)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
+
+	var newId int
+	err := repo.DB.QueryRowContext(ctx, stmt,
+		r.UserID,
+		r.Name,
+		r.Priority,
+		r.Enabled,
+		r.Action,
+		r.EventName,
+		r.Venue,
+		r.Sections,
+		r.MinPrice,
+		r.MaxPrice,
+		r.MinQuantity,
+		r.MaxQuantity,
+		r.StockType,
+	).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, nil
+}
+
+// SetRuleEnabled turns a rule on or off
+func (repo *postgresDBRepo) SetRuleEnabled(id int, enabled bool) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `update "rules".rules set enabled = $1 where id = $2`, enabled, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteRule removes a rule. Its past decisions are kept.
+func (repo *postgresDBRepo) DeleteRule(id int) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	_, err := repo.DB.ExecContext(ctx, `delete from "rules".rules where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertRuleDecisions records why rules matched a cart
+func (repo *postgresDBRepo) InsertRuleDecisions(decisions []models.RuleDecision) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "rules".decisions (cart_id, rule_id, rule_name, user_id, action, reasons)
+			values ($1, $2, $3, $4, $5, $6)`
+
+	for _, d := range decisions {
+		var reasons pgtype.TextArray
+		if err := reasons.Set(d.Reasons); err != nil {
+			return err
+		}
+		_, err := repo.DB.ExecContext(ctx, stmt, d.CartID, d.RuleID, d.RuleName, d.UserID, d.Action, &reasons)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RecentRuleDecisions returns the latest rule decisions, newest first
+func (repo *postgresDBRepo) RecentRuleDecisions(limit int) ([]models.RuleDecision, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select cart_id, rule_id, rule_name, user_id, action, reasons, created_at
+			from "rules".decisions order by created_at desc limit $1`
+
+	rows, err := repo.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var decisions []models.RuleDecision
+
+	for rows.Next() {
+		var d models.RuleDecision
+		var reasons pgtype.TextArray
+		err = rows.Scan(&d.CartID, &d.RuleID, &d.RuleName, &d.UserID, &d.Action, &reasons, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err = reasons.AssignTo(&d.Reasons); err != nil {
+			return nil, err
+		}
+		decisions = append(decisions, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return decisions, nil
+}
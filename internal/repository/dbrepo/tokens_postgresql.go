@@ -0,0 +1,80 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
+
+// InsertAPIToken stores a new api token and returns its id
+func (repo *postgresDBRepo) InsertAPIToken(t models.APIToken) (int, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into "users".api_tokens (user_id, name, token_hash) values ($1, $2, $3) returning id`
+
+	var id int
+	err := repo.DB.QueryRowContext(ctx, stmt, t.UserID, t.Name, t.TokenHash).Scan(&id)
+	return id, err
+}
+
+// RevokeAPIToken revokes an api token so it can no longer be used
+func (repo *postgresDBRepo) RevokeAPIToken(id int) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `update "users".api_tokens set revoked_at = now()
+			where id = $1 and revoked_at is null`, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// AllAPITokens returns every api token, newest first. Revoked tokens are included.
+func (repo *postgresDBRepo) AllAPITokens() ([]models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, user_id, name, last_used_at, revoked_at, created_at
+			from "users".api_tokens order by created_at desc`
+
+	rows, err := repo.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var t models.APIToken
+		var lastUsed, revoked sql.NullTime
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &lastUsed, &revoked, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsedAt = lastUsed.Time
+		t.RevokedAt = revoked.Time
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// GetUserByAPIToken returns the user an unrevoked token belongs to, and notes that it was used
+func (repo *postgresDBRepo) GetUserByAPIToken(hash string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	var userID string
+	err := repo.DB.QueryRowContext(ctx, `update "users".api_tokens set last_used_at = now()
+			where token_hash = $1 and revoked_at is null returning user_id`, hash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return models.User{}, models.ErrInvalidCredentials
+	} else if err != nil {
+		return models.User{}, err
+	}
+
+	return repo.GetUserById(userID)
+}
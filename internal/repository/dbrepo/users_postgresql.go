@@ -5,16 +5,16 @@ import (
 	"database/sql"
 	"github.com/SeatSnobAri/seatflipsite/internal/models"
 	"golang.org/x/crypto/bcrypt"
-	"log"
 	"time"
 )
 
 // AllUsers returns all users
 func (repo *postgresDBRepo) AllUsers() ([]*models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
-	stmt := `SELECT id, last_name, first_name, email, photo,verified,provider, created_at, updated_at FROM "users".users`
+	stmt := `SELECT id, last_name, first_name, email, photo,verified,provider, team, access_level, status, created_at, updated_at
+			FROM "users".users order by last_name, first_name`
 
 	rows, err := repo.DB.QueryContext(ctx, stmt)
 	if err != nil {
@@ -26,7 +26,7 @@ func (repo *postgresDBRepo) AllUsers() ([]*models.User, error) {
 
 	for rows.Next() {
 		s := &models.User{}
-		err = rows.Scan(&s.ID, &s.LastName, &s.FirstName, &s.Email, &s.Photo, &s.Verified, &s.Provider, &s.CreatedAt, &s.UpdatedAt)
+		err = rows.Scan(&s.ID, &s.LastName, &s.FirstName, &s.Email, &s.Photo, &s.Verified, &s.Provider, &s.Team, &s.AccessLevel, &s.Status, &s.CreatedAt, &s.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -35,7 +35,6 @@ func (repo *postgresDBRepo) AllUsers() ([]*models.User, error) {
 	}
 
 	if err = rows.Err(); err != nil {
-		log.Println(err)
 		return nil, err
 	}
 
@@ -44,10 +43,10 @@ func (repo *postgresDBRepo) AllUsers() ([]*models.User, error) {
 
 // GetUserById returns a user by id
 func (repo *postgresDBRepo) GetUserById(id string) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
-	stmt := `SELECT id, last_name, first_name, email, photo, verified, provider, created_at, updated_at 
+	stmt := `SELECT id, last_name, first_name, email, photo, verified, provider, team, access_level, status, created_at, updated_at
 			FROM 
 			    "users".users
 		 	where 
@@ -65,12 +64,14 @@ func (repo *postgresDBRepo) GetUserById(id string) (models.User, error) {
 		&u.Photo,
 		&u.Verified,
 		&u.Provider,
+		&u.Team,
+		&u.AccessLevel,
+		&u.Status,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
 
 	if err != nil {
-		log.Println(err)
 		return u, err
 	}
 
@@ -79,7 +80,7 @@ func (repo *postgresDBRepo) GetUserById(id string) (models.User, error) {
 
 // Authenticate authenticates
 func (repo *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
 	var id int
@@ -100,7 +101,6 @@ func (repo *postgresDBRepo) Authenticate(email, testPassword string) (int, strin
 	if err == sql.ErrNoRows {
 		return 0, "", models.ErrInvalidCredentials
 	} else if err != nil {
-		log.Println(err)
 		return 0, "", err
 	}
 
@@ -108,7 +108,6 @@ func (repo *postgresDBRepo) Authenticate(email, testPassword string) (int, strin
 	if err == bcrypt.ErrMismatchedHashAndPassword {
 		return 0, "", models.ErrInvalidCredentials
 	} else if err != nil {
-		log.Println(err)
 		return 0, "", err
 	}
 
@@ -122,7 +121,7 @@ func (repo *postgresDBRepo) Authenticate(email, testPassword string) (int, strin
 
 // InsertRememberMeToken inserts a remember me token into remember_tokens for a user
 func (repo *postgresDBRepo) InsertRememberMeToken(id int, token string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
 	stmt := "insert into remember_tokens (user_id, remember_token) values ($1, $2)"
@@ -135,7 +134,7 @@ func (repo *postgresDBRepo) InsertRememberMeToken(id int, token string) error {
 
 // DeleteToken deletes a remember me token
 func (repo *postgresDBRepo) DeleteToken(token string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
 	stmt := "delete from remember_tokens where remember_token = $1"
@@ -149,7 +148,7 @@ func (repo *postgresDBRepo) DeleteToken(token string) error {
 
 // CheckForToken checks for a valid remember me token
 func (repo *postgresDBRepo) CheckForToken(id int, token string) bool {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
 	stmt := "SELECT id  FROM remember_tokens where user_id = $1 and remember_token = $2"
@@ -160,7 +159,7 @@ func (repo *postgresDBRepo) CheckForToken(id int, token string) bool {
 
 // Insert method to add a new record to the users table.
 func (repo *postgresDBRepo) InsertUser(u models.User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 
 	stmt := `
@@ -195,11 +194,10 @@ func (repo *postgresDBRepo) InsertUser(u models.User) (int, error) {
 	return newId, err
 }
 func (repo *postgresDBRepo) AddUser(u models.GoogleUserResult) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
 	defer cancel()
 	query := `insert into "users".users (id, first_name, last_name, email,photo,provider,verified, created_at, updated_at)
 				values ($1,$2,$3,$4,$5,$6,$7,$8, $9)`
-	log.Printf("%+v", u)
 	_, err := repo.DB.ExecContext(ctx, query,
 		&u.Id,
 		&u.Given_name,
@@ -216,3 +214,39 @@ func (repo *postgresDBRepo) AddUser(u models.GoogleUserResult) error {
 	}
 	return nil
 }
+
+// SetUserStatus approves, disables or re-enables a user
+func (repo *postgresDBRepo) SetUserStatus(id, status string) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `update "users".users set status = $1 where id = $2`, status, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// SetUserAccessLevel changes a user's role
+func (repo *postgresDBRepo) SetUserAccessLevel(id string, level int) error {
+	ctx, cancel := context.WithTimeout(repo.baseContext(), 3*time.Second)
+	defer cancel()
+
+	res, err := repo.DB.ExecContext(ctx, `update "users".users set access_level = $1 where id = $2`, level, id)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// requireRow returns ErrNoRecord when a statement changed nothing
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+	return nil
+}
@@ -1,8 +1,15 @@
 package repository
 
-import "github.com/SeatSnobAri/seatflipsite/internal/models"
+import (
+	"context"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"time"
+)
 
 type DatabaseRepo interface {
+	// WithContext returns a copy whose queries carry the values of ctx, such as the trace span
+	WithContext(ctx context.Context) DatabaseRepo
+
 	// users and authentication
 	GetUserById(id string) (models.User, error)
 	InsertUser(u models.User) (int, error)
@@ -11,9 +18,117 @@ type DatabaseRepo interface {
 	InsertRememberMeToken(id int, token string) error
 	CheckForToken(id int, token string) bool
 	AddUser(u models.GoogleUserResult) error
+	SetUserStatus(id, status string) error
+	SetUserAccessLevel(id string, level int) error
+
+	// api tokens
+	InsertAPIToken(t models.APIToken) (int, error)
+	RevokeAPIToken(id int) error
+	AllAPITokens() ([]models.APIToken, error)
+	GetUserByAPIToken(hash string) (models.User, error)
 
 	// cart info
-	InsertCart(payload models.UflipPayload, user models.UserPayload) error
+	InsertCart(payload models.UflipPayload, userID string, msgs ...models.OutboxMessage) error
 	UpdateCart(buy bool, id string) error
 	GetCartUser(id string) string
+	GetCartById(id string) (models.Cart, error)
+	RecentCarts(limit int, openOnly bool) ([]models.Cart, error)
+	AddApproval(cartID, userID string) (int, error)
+
+	// inventory
+	InsertInventoryFromCart(cart models.Cart) (int, error)
+	AllInventory() ([]*models.InventoryItem, error)
+	GetInventoryById(id int) (models.InventoryItem, error)
+	UpdateInventory(i models.InventoryItem) error
+
+	// reports
+	EventReport(f models.ReportFilter) ([]models.EventReport, error)
+	AgentReport(f models.ReportFilter) ([]models.SpendReport, error)
+	TeamReport(f models.ReportFilter) ([]models.SpendReport, error)
+	PeriodReport(f models.ReportFilter) ([]models.SpendReport, error)
+
+	// exports
+	ExportCarts(ctx context.Context, f models.ExportFilter, fn func(models.Cart) error) error
+	ExportInventory(ctx context.Context, f models.ExportFilter, fn func(models.InventoryItem) error) error
+
+	// imports
+	CartExists(id string) (bool, error)
+	ImportPurchases(purchases []models.ImportedPurchase) (int, error)
+
+	// budgets
+	AllBudgets() ([]models.Budget, error)
+	SaveBudget(b models.Budget) error
+	DeleteBudget(id int) error
+	BudgetStatus(buyerID string) ([]models.BudgetStatus, error)
+	BuyCart(cartID, buyerID string, override bool, orderID int) error
+	RequestOverride(cartID string, requested bool) error
+	PendingOverrides() ([]models.Cart, error)
+
+	// rules
+	AllRules() ([]models.Rule, error)
+	EnabledRules() ([]models.Rule, error)
+	GetRuleById(id int) (models.Rule, error)
+	InsertRule(r models.Rule) (int, error)
+	SetRuleEnabled(id int, enabled bool) error
+	DeleteRule(id int) error
+	InsertRuleDecisions(decisions []models.RuleDecision) error
+	RecentRuleDecisions(limit int) ([]models.RuleDecision, error)
+
+	// snipe orders
+	InsertSnipeOrder(o models.SnipeOrder) (int, error)
+	UpdateSnipeOrder(o models.SnipeOrder) error
+	GetSnipeOrderById(id int) (models.SnipeOrder, error)
+	AllSnipeOrders(limit int) ([]models.SnipeOrder, error)
+	OpenSnipeOrders() ([]models.SnipeOrder, error)
+	SetSnipeOrderStatus(id int, status string) error
+	ExpireSnipeOrders() ([]int, error)
+	TagCartWithOrder(cartID string, orderID int) error
+
+	// checkout confirmations
+	ConfirmCart(c models.Confirmation) (int, error)
+	AllConfirmations(limit int, flaggedOnly bool) ([]models.Confirmation, error)
+
+	// attachments
+	InsertAttachment(a models.Attachment) (int, error)
+	GetAttachmentById(id int) (models.Attachment, error)
+	InventoryAttachments() (map[int][]models.Attachment, error)
+
+	// audit log
+	InsertAuditEvent(e models.AuditEvent) error
+	AuditEvents(f models.AuditFilter, limit int) ([]models.AuditEvent, error)
+	ExportAuditEvents(ctx context.Context, f models.AuditFilter, fn func(models.AuditEvent) error) error
+
+	// cart history
+	InsertCartEvent(e models.CartEvent) error
+	CartEvents(cartID string) ([]models.CartEvent, error)
+	CartEventsAt(at time.Time, window time.Duration) ([]models.CartEvent, error)
+	InsertCartExpiry(cartID string) (bool, error)
+	UnexpiredCarts(since time.Time) ([]string, error)
+
+	// live carts, when they are held in postgres
+	PutLiveCart(id string, value []byte, expiresAt time.Time) error
+	GetLiveCart(id string) ([]byte, bool, error)
+	UpdateLiveCart(id string, value []byte) error
+	LiveCarts() ([][]byte, error)
+	ExpireLiveCartAt(id string, at time.Time) (bool, error)
+	SweepLiveCarts() (int, error)
+	ListenLiveCartExpiries(ctx context.Context, listening func(), expired func(id string)) error
+
+	// outbox
+	DispatchOutbox(limit int, fn func(models.OutboxMessage) error, backoff func(attempts int) (time.Duration, bool)) (int, error)
+	PruneOutbox(before time.Time) (int64, error)
+
+	// jobs
+	EnqueueJob(j models.Job) (int64, error)
+	ClaimJob(kinds []string, stale time.Duration) (models.Job, bool, error)
+	CompleteJob(id int64, result []byte) error
+	RetryJob(id int64, lastError string, runAt time.Time) error
+	FailJob(id int64, lastError string) error
+	GetJobById(id int64) (models.Job, error)
+	RecentJobs(limit int) ([]models.Job, error)
+	ScheduleJob(name string, next time.Time, j models.Job) (bool, error)
+	PruneJobs(before time.Time) (int64, error)
+
+	// sessions
+	DeleteExpiredSessions() (int64, error)
 }
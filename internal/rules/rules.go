@@ -0,0 +1,147 @@
+package rules
+
+import (
+	"fmt"
+	"github.com/SeatSnobAri/seatflipsite/internal/models"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var sectionRegex = regexp.MustCompile(`(?i)\bsec(?:tion)?\.?\s*([a-z0-9]+)`)
+
+// Evaluate runs enabled rules against a cart in priority order and returns a decision for each rule that
+// matches. The first approve or decline decision is final, so no rules after it are run.
+func Evaluate(rules []models.Rule, cart models.UflipPayload) []models.RuleDecision {
+	sorted := make([]models.Rule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	var decisions []models.RuleDecision
+
+	for _, rule := range sorted {
+		if !rule.Enabled {
+			continue
+		}
+		ok, reasons := Match(rule, cart)
+		if !ok {
+			continue
+		}
+
+		decisions = append(decisions, models.RuleDecision{
+			CartID:   cart.UUID,
+			RuleID:   rule.ID,
+			RuleName: rule.Name,
+			UserID:   rule.UserID,
+			Action:   rule.Action,
+			Reasons:  reasons,
+		})
+
+		if rule.Action == models.RuleApprove || rule.Action == models.RuleDecline {
+			break
+		}
+	}
+
+	return decisions
+}
+
+// Verdict returns the approve or decline decision, if any rule made one
+func Verdict(decisions []models.RuleDecision) (models.RuleDecision, bool) {
+	for _, d := range decisions {
+		if d.Action == models.RuleApprove || d.Action == models.RuleDecline {
+			return d, true
+		}
+	}
+	return models.RuleDecision{}, false
+}
+
+// Match reports whether the cart meets every condition of the rule, along with a reason for each
+// condition that was checked
+func Match(rule models.Rule, cart models.UflipPayload) (bool, []string) {
+	var reasons []string
+
+	if rule.EventName != "" {
+		if !containsFold(cart.EventName, rule.EventName) {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("event %q contains %q", cart.EventName, rule.EventName))
+	}
+
+	if rule.Venue != "" {
+		if !containsFold(cart.EventVenue, rule.Venue) {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("venue %q contains %q", cart.EventVenue, rule.Venue))
+	}
+
+	if rule.Sections != "" {
+		section := Section(cart.SeatInfo)
+		matched := false
+		for _, s := range strings.Split(rule.Sections, ",") {
+			if s = strings.TrimSpace(s); s != "" && strings.EqualFold(section, s) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("section %s is in %s", section, rule.Sections))
+	}
+
+	price := cart.PricePerTicket()
+	if rule.MinPrice > 0 {
+		if price < rule.MinPrice {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("price per ticket $%.2f is at least $%.2f", price, rule.MinPrice))
+	}
+	if rule.MaxPrice > 0 {
+		if price > rule.MaxPrice {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("price per ticket $%.2f is at most $%.2f", price, rule.MaxPrice))
+	}
+
+	quantity := cart.Quantity
+	if rule.MinQuantity > 0 {
+		if quantity < rule.MinQuantity {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("quantity %d is at least %d", quantity, rule.MinQuantity))
+	}
+	if rule.MaxQuantity > 0 {
+		if quantity > rule.MaxQuantity {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("quantity %d is at most %d", quantity, rule.MaxQuantity))
+	}
+
+	if rule.StockType != "" {
+		if !strings.EqualFold(cart.StockType, rule.StockType) {
+			return false, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("stock type is %s", cart.StockType))
+	}
+
+	if len(reasons) == 0 {
+		reasons = append(reasons, "rule has no conditions")
+	}
+
+	return true, reasons
+}
+
+// Section reads the section out of seat info such as "Sec 104, Row F, Seats 3-4". When no section
+// label is found the whole seat info is returned.
+func Section(seatInfo string) string {
+	if m := sectionRegex.FindStringSubmatch(seatInfo); m != nil {
+		return m[1]
+	}
+	return strings.TrimSpace(seatInfo)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
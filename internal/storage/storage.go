@@ -0,0 +1,113 @@
+// Package storage keeps uploaded files such as receipts, checkout screenshots and ticket PDFs.
+// Files are content addressed: the key of a file is the hex SHA-256 of its bytes, so the same file
+// uploaded twice is only stored once.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+var (
+	// ErrNotFound no object stored under the key error
+	ErrNotFound = errors.New("storage: no object stored under that key")
+	// ErrTooLarge upload over the size limit error
+	ErrTooLarge = errors.New("storage: file is too large")
+	// ErrUnsupportedType upload content type not allowed error
+	ErrUnsupportedType = errors.New("storage: file type is not allowed")
+	// ErrInvalidKey key is not a SHA-256 hex digest error
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+// MaxSize is the largest file that can be stored
+const MaxSize = 10 << 20
+
+// AllowedTypes are the content types that can be stored, sniffed from the file itself
+var AllowedTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf"}
+
+// Store is a place to keep files by key. The local disk is the only backend today; an object
+// store such as S3 only needs to implement these methods.
+type Store interface {
+	// Put stores the contents of r under key, replacing anything already there
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens the object stored under key, or returns ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether an object is stored under key
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete removes the object stored under key
+	Delete(ctx context.Context, key string) error
+}
+
+// Object describes a stored file
+type Object struct {
+	Key         string
+	Size        int64
+	ContentType string
+}
+
+// Save validates the file read from r and stores it under its SHA-256, unless it is already stored
+func Save(ctx context.Context, s Store, r io.Reader) (Object, error) {
+	b, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return Object{}, err
+	}
+
+	o, err := Describe(b)
+	if err != nil {
+		return o, err
+	}
+
+	ok, err := s.Exists(ctx, o.Key)
+	if err != nil || ok {
+		return o, err
+	}
+
+	return o, s.Put(ctx, o.Key, bytes.NewReader(b))
+}
+
+// Describe checks the size and content type of b and works out its key
+func Describe(b []byte) (Object, error) {
+	if len(b) > MaxSize {
+		return Object{}, fmt.Errorf("%w: the limit is %d MB", ErrTooLarge, MaxSize>>20)
+	}
+
+	contentType := http.DetectContentType(b)
+	if !allowed(contentType) {
+		return Object{}, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	sum := sha256.Sum256(b)
+	return Object{
+		Key:         hex.EncodeToString(sum[:]),
+		Size:        int64(len(b)),
+		ContentType: contentType,
+	}, nil
+}
+
+func allowed(contentType string) bool {
+	for _, t := range AllowedTypes {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// validKey reports whether key is a lower case hex SHA-256, which keeps keys from escaping the store
+func validKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
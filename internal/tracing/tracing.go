@@ -0,0 +1,215 @@
+// Package tracing sets up OpenTelemetry tracing. Each http request and broker action is a span, with
+// child spans for the Postgres queries, redis commands and Pusher triggers made while answering it.
+// Trace context comes in, from the extension, and goes out in W3C traceparent headers. Spans are
+// exported over OTLP, or printed to stdout for local work.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v4"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+// exporters
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// instrumentation names the tracer the application's spans come from
+const instrumentation = "github.com/SeatSnobAri/seatflipsite"
+
+// Options configure tracing
+type Options struct {
+	// Exporter is ExporterNone, ExporterOTLP or ExporterStdout
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector; when empty the OTEL_EXPORTER_OTLP_*
+	// environment variables, or localhost:4318, are used
+	Endpoint string
+	// Insecure sends spans to the collector over plain http
+	Insecure bool
+	// SampleRatio is the share of traces started here that are recorded. Traces started by the
+	// extension follow its decision.
+	SampleRatio float64
+	// Version is the version of the service
+	Version string
+}
+
+// Setup installs the global propagator and, unless the exporter is none, a tracer provider. The
+// function it returns sends the spans still buffered and stops the exporter.
+func Setup(ctx context.Context, opts Options) (func(ctx context.Context) error, error) {
+	// trace context is passed on even with tracing off, so a trace from the extension isn't broken
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case ExporterNone:
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var o []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			o = append(o, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			o = append(o, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, o...)
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		err = fmt.Errorf("unknown exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithAttributes(semconv.ServiceName("seatflip"), semconv.ServiceVersion(opts.Version)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))))
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// Tracer returns the tracer the application's spans come from
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Start starts a span, as a child of the span in ctx if there is one
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it failed if err isn't nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		Fail(span, err)
+	}
+	span.End()
+}
+
+// Fail records err on span and marks it failed
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Trigger traces send, which triggers event on a Pusher channel
+func Trigger(ctx context.Context, channel, event string, send func() error) error {
+	_, span := Tracer().Start(ctx, "pusher trigger",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("pusher.channel", channel), attribute.String("pusher.event", event)))
+	err := send()
+	End(span, err)
+	return err
+}
+
+// traced reports whether ctx is part of a trace. Queries and commands made outside of one, by the
+// background workers, aren't worth a trace of their own.
+func traced(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
+
+// RedisHook traces every redis command made as part of a trace
+type RedisHook struct{}
+
+// DialHook passes dials through
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook traces a command; a missing key is not an error
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !traced(ctx) {
+			return next(ctx, cmd)
+		}
+		name := strings.ToLower(cmd.Name())
+		ctx, span := Tracer().Start(ctx, "redis "+name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, semconv.DBOperation(name)))
+		err := next(ctx, cmd)
+		End(span, redisErr(err))
+		return err
+	}
+}
+
+// ProcessPipelineHook traces a pipeline as one span
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !traced(ctx) {
+			return next(ctx, cmds)
+		}
+		ctx, span := Tracer().Start(ctx, "redis pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemRedis, attribute.Int("db.redis.commands", len(cmds))))
+		err := next(ctx, cmds)
+		End(span, redisErr(err))
+		return err
+	}
+}
+
+func redisErr(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// PgxLogger traces every query and exec made as part of a trace, from the log entries pgx writes
+// when they finish, so each span is started back when the query was. The statement is recorded
+// with its placeholders; its arguments are not. Set it as the connection's Logger with a level of
+// pgx.LogLevelInfo.
+type PgxLogger struct{}
+
+// Log records the entries that carry a duration
+func (PgxLogger) Log(ctx context.Context, level pgx.LogLevel, msg string, data map[string]interface{}) {
+	took, ok := data["time"].(time.Duration)
+	if !ok || !traced(ctx) {
+		return
+	}
+	end := time.Now()
+	operation := strings.ToLower(msg)
+
+	attrs := []attribute.KeyValue{semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)}
+	if sql, ok := data["sql"].(string); ok {
+		attrs = append(attrs, semconv.DBStatement(sql))
+	}
+	_, span := Tracer().Start(ctx, "postgres "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(end.Add(-took)),
+		trace.WithAttributes(attrs...))
+
+	if err, _ := data["err"].(error); err != nil {
+		Fail(span, err)
+	}
+	span.End(trace.WithTimestamp(end))
+}
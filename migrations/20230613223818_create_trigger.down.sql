@@ -0,0 +1 @@
+drop function trigger_set_timestamp();
@@ -0,0 +1,2 @@
+drop table users.users;
+drop schema users;
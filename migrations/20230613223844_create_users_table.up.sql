@@ -0,0 +1,20 @@
+CREATE SCHEMA IF NOT EXISTS users;
+
+CREATE TABLE IF NOT EXISTS users.users (
+                                           id         TEXT PRIMARY KEY,
+                                           first_name VARCHAR(255) NOT NULL DEFAULT '',
+                                           last_name  VARCHAR(255) NOT NULL DEFAULT '',
+                                           email      TEXT NOT NULL DEFAULT '',
+                                           photo      VARCHAR(255) NOT NULL DEFAULT '',
+                                           provider   VARCHAR(255) NOT NULL DEFAULT '',
+                                           verified   BOOLEAN NOT NULL DEFAULT FALSE,
+                                           created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                           updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+DROP TRIGGER IF EXISTS set_timestamp ON users.users;
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON users.users
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
@@ -0,0 +1,2 @@
+drop table carts.carts;
+drop schema carts;
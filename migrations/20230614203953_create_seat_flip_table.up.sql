@@ -0,0 +1,27 @@
+CREATE SCHEMA IF NOT EXISTS carts;
+
+CREATE TABLE IF NOT EXISTS carts.carts (
+                                           id           TEXT PRIMARY KEY,
+                                           event_date   TEXT NOT NULL DEFAULT '',
+                                           event_name   TEXT NOT NULL DEFAULT '',
+                                           event_venue  TEXT NOT NULL DEFAULT '',
+                                           seat_info    TEXT NOT NULL DEFAULT '',
+                                           ticket_info  TEXT NOT NULL DEFAULT '',
+                                           ticket_price TEXT NOT NULL DEFAULT '',
+                                           ticket_total NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                           bought       BOOLEAN NOT NULL DEFAULT FALSE,
+                                           stock_type   TEXT NOT NULL DEFAULT '',
+                                           user_id      TEXT NOT NULL DEFAULT '',
+                                           created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                           updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS carts_user_id_idx ON carts.carts (user_id);
+CREATE INDEX IF NOT EXISTS carts_created_at_idx ON carts.carts (created_at);
+
+DROP TRIGGER IF EXISTS set_timestamp ON carts.carts;
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON carts.carts
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
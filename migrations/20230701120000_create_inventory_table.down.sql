@@ -0,0 +1,2 @@
+drop table inventory.inventory;
+drop schema inventory;
\ No newline at end of file
@@ -0,0 +1,27 @@
+CREATE SCHEMA IF NOT EXISTS inventory;
+
+CREATE TABLE inventory.inventory (
+                                     id            SERIAL PRIMARY KEY,
+                                     cart_id       TEXT NOT NULL UNIQUE,
+                                     user_id       TEXT NOT NULL,
+                                     event_date    TEXT NOT NULL DEFAULT '',
+                                     event_name    TEXT NOT NULL DEFAULT '',
+                                     event_venue   TEXT NOT NULL DEFAULT '',
+                                     seat_info     TEXT NOT NULL DEFAULT '',
+                                     state         TEXT NOT NULL DEFAULT 'purchased'
+                                         CHECK (state IN ('purchased', 'listed', 'sold', 'transferred', 'delivered', 'refunded')),
+                                     quantity      INTEGER NOT NULL DEFAULT 1,
+                                     cost_basis    NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                     fees          NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                     listing_price NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                     sale_price    NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                     created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                     updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX inventory_state_idx ON inventory.inventory (state);
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON inventory.inventory
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
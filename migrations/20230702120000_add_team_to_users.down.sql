@@ -0,0 +1 @@
+ALTER TABLE users.users DROP COLUMN team;
\ No newline at end of file
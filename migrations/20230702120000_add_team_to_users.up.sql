@@ -0,0 +1,3 @@
+ALTER TABLE users.users ADD COLUMN team TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX users_team_idx ON users.users (team);
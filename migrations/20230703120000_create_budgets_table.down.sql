@@ -0,0 +1,8 @@
+drop table budgets.budgets;
+drop schema budgets;
+
+ALTER TABLE carts.carts DROP COLUMN override_requested;
+ALTER TABLE carts.carts DROP COLUMN bought_at;
+ALTER TABLE carts.carts DROP COLUMN bought_by;
+
+ALTER TABLE users.users DROP COLUMN access_level;
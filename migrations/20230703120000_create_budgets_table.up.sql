@@ -0,0 +1,28 @@
+-- users from before access levels could already buy, so they keep that as buyers; everyone who
+-- signs up afterwards starts as an agent. Admins are granted with web users set-role.
+ALTER TABLE users.users ADD COLUMN access_level INTEGER NOT NULL DEFAULT 2;
+ALTER TABLE users.users ALTER COLUMN access_level SET DEFAULT 1;
+
+ALTER TABLE carts.carts ADD COLUMN bought_by TEXT;
+ALTER TABLE carts.carts ADD COLUMN bought_at TIMESTAMPTZ;
+ALTER TABLE carts.carts ADD COLUMN override_requested BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX carts_bought_at_idx ON carts.carts (bought_at) WHERE bought;
+
+CREATE SCHEMA IF NOT EXISTS budgets;
+
+CREATE TABLE budgets.budgets (
+                                 id         SERIAL PRIMARY KEY,
+                                 scope      TEXT NOT NULL CHECK (scope IN ('buyer', 'team', 'event')),
+                                 scope_key  TEXT NOT NULL,
+                                 amount     NUMERIC(12, 2) NOT NULL CHECK (amount >= 0),
+                                 period     TEXT NOT NULL DEFAULT 'day' CHECK (period IN ('day', 'week', 'month', 'total')),
+                                 created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                 updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                 UNIQUE (scope, scope_key)
+);
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON budgets.budgets
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
@@ -0,0 +1 @@
+drop table carts.approvals;
\ No newline at end of file
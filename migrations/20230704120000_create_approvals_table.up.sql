@@ -0,0 +1,6 @@
+CREATE TABLE carts.approvals (
+                                 cart_id    TEXT NOT NULL,
+                                 user_id    TEXT NOT NULL,
+                                 created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                 PRIMARY KEY (cart_id, user_id)
+);
@@ -0,0 +1,3 @@
+drop table rules.decisions;
+drop table rules.rules;
+drop schema rules;
\ No newline at end of file
@@ -0,0 +1,39 @@
+CREATE SCHEMA IF NOT EXISTS rules;
+
+CREATE TABLE rules.rules (
+                             id           SERIAL PRIMARY KEY,
+                             user_id      TEXT NOT NULL,
+                             name         TEXT NOT NULL,
+                             priority     INTEGER NOT NULL DEFAULT 100,
+                             enabled      BOOLEAN NOT NULL DEFAULT TRUE,
+                             action       TEXT NOT NULL CHECK (action IN ('approve', 'decline', 'flag', 'notify')),
+                             event_name   TEXT NOT NULL DEFAULT '',
+                             venue        TEXT NOT NULL DEFAULT '',
+                             sections     TEXT NOT NULL DEFAULT '',
+                             min_price    NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                             max_price    NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                             min_quantity INTEGER NOT NULL DEFAULT 0,
+                             max_quantity INTEGER NOT NULL DEFAULT 0,
+                             stock_type   TEXT NOT NULL DEFAULT '',
+                             created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                             updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON rules.rules
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
+
+CREATE TABLE rules.decisions (
+                                 id         SERIAL PRIMARY KEY,
+                                 cart_id    TEXT NOT NULL,
+                                 rule_id    INTEGER NOT NULL,
+                                 rule_name  TEXT NOT NULL,
+                                 user_id    TEXT NOT NULL,
+                                 action     TEXT NOT NULL,
+                                 reasons    TEXT[] NOT NULL DEFAULT '{}',
+                                 created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX decisions_cart_id_idx ON rules.decisions (cart_id);
+CREATE INDEX decisions_created_at_idx ON rules.decisions (created_at);
@@ -0,0 +1,4 @@
+ALTER TABLE carts.carts DROP COLUMN snipe_order_id;
+
+drop table orders.snipe_orders;
+drop schema orders;
\ No newline at end of file
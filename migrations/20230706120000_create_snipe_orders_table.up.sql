@@ -0,0 +1,28 @@
+CREATE SCHEMA IF NOT EXISTS orders;
+
+CREATE TABLE orders.snipe_orders (
+                                     id                SERIAL PRIMARY KEY,
+                                     user_id           TEXT NOT NULL,
+                                     event_name        TEXT NOT NULL,
+                                     venue             TEXT NOT NULL DEFAULT '',
+                                     sections          TEXT NOT NULL DEFAULT '',
+                                     max_price         NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                     quantity          INTEGER NOT NULL DEFAULT 1,
+                                     auto_approve      BOOLEAN NOT NULL DEFAULT FALSE,
+                                     notes             TEXT NOT NULL DEFAULT '',
+                                     status            TEXT NOT NULL DEFAULT 'open'
+                                         CHECK (status IN ('open', 'fulfilled', 'cancelled', 'expired')),
+                                     fulfilled_cart_id TEXT,
+                                     expires_at        TIMESTAMPTZ NOT NULL,
+                                     created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                     updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX snipe_orders_status_idx ON orders.snipe_orders (status, expires_at);
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON orders.snipe_orders
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
+
+ALTER TABLE carts.carts ADD COLUMN snipe_order_id INTEGER;
@@ -0,0 +1 @@
+drop table carts.confirmations;
\ No newline at end of file
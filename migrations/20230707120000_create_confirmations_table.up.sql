@@ -0,0 +1,23 @@
+CREATE TABLE carts.confirmations (
+                                     id              SERIAL PRIMARY KEY,
+                                     cart_id         TEXT NOT NULL UNIQUE,
+                                     user_id         TEXT NOT NULL,
+                                     order_number    TEXT NOT NULL,
+                                     charged_total   NUMERIC(12, 2) NOT NULL,
+                                     fees            NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                     approved_total  NUMERIC(12, 2) NOT NULL,
+                                     discrepancy     NUMERIC(12, 2) NOT NULL DEFAULT 0,
+                                     flagged         BOOLEAN NOT NULL DEFAULT FALSE,
+                                     attachment_name TEXT NOT NULL DEFAULT '',
+                                     attachment_type TEXT NOT NULL DEFAULT '',
+                                     attachment      BYTEA,
+                                     created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                     updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX confirmations_flagged_idx ON carts.confirmations (flagged, created_at);
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON carts.confirmations
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
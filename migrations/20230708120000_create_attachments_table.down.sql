@@ -0,0 +1,8 @@
+ALTER TABLE carts.confirmations
+    DROP COLUMN attachment_id,
+    ADD COLUMN attachment_name TEXT NOT NULL DEFAULT '',
+    ADD COLUMN attachment_type TEXT NOT NULL DEFAULT '',
+    ADD COLUMN attachment BYTEA;
+
+drop table storage.attachments;
+drop schema storage;
@@ -0,0 +1,24 @@
+CREATE SCHEMA IF NOT EXISTS storage;
+
+CREATE TABLE storage.attachments (
+                                     id           SERIAL PRIMARY KEY,
+                                     sha256       TEXT NOT NULL,
+                                     size         BIGINT NOT NULL,
+                                     content_type TEXT NOT NULL,
+                                     filename     TEXT NOT NULL DEFAULT '',
+                                     cart_id      TEXT,
+                                     inventory_id INTEGER REFERENCES inventory.inventory (id) ON DELETE CASCADE,
+                                     user_id      TEXT NOT NULL,
+                                     created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                     CHECK (cart_id IS NOT NULL OR inventory_id IS NOT NULL)
+);
+
+CREATE INDEX attachments_cart_id_idx ON storage.attachments (cart_id);
+CREATE INDEX attachments_inventory_id_idx ON storage.attachments (inventory_id);
+
+-- confirmation screenshots and PDFs move out of the database and into the attachment store
+ALTER TABLE carts.confirmations
+    DROP COLUMN attachment,
+    DROP COLUMN attachment_name,
+    DROP COLUMN attachment_type,
+    ADD COLUMN attachment_id INTEGER REFERENCES storage.attachments (id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+drop table audit.audit_events;
+drop function audit.reject_change();
+drop schema audit;
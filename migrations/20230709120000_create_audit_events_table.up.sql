@@ -0,0 +1,34 @@
+CREATE SCHEMA IF NOT EXISTS audit;
+
+CREATE TABLE audit.audit_events (
+                                    id          BIGSERIAL PRIMARY KEY,
+                                    actor       TEXT NOT NULL,
+                                    action      TEXT NOT NULL,
+                                    target_type TEXT NOT NULL DEFAULT '',
+                                    target_id   TEXT NOT NULL DEFAULT '',
+                                    before      JSONB,
+                                    after       JSONB,
+                                    ip          TEXT NOT NULL DEFAULT '',
+                                    user_agent  TEXT NOT NULL DEFAULT '',
+                                    method      TEXT NOT NULL DEFAULT '',
+                                    path        TEXT NOT NULL DEFAULT '',
+                                    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_events_created_at_idx ON audit.audit_events (created_at);
+CREATE INDEX audit_events_actor_idx ON audit.audit_events (actor, created_at);
+CREATE INDEX audit_events_action_idx ON audit.audit_events (action, created_at);
+CREATE INDEX audit_events_target_idx ON audit.audit_events (target_type, target_id);
+
+-- the audit log is append only
+CREATE OR REPLACE FUNCTION audit.reject_change()
+    RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit events can not be changed or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit.audit_events
+    FOR EACH ROW
+EXECUTE PROCEDURE audit.reject_change();
@@ -0,0 +1,2 @@
+drop table carts.cart_events;
+drop function carts.reject_event_change();
@@ -0,0 +1,26 @@
+CREATE TABLE carts.cart_events (
+                                   id         BIGSERIAL PRIMARY KEY,
+                                   cart_id    TEXT NOT NULL,
+                                   type       TEXT NOT NULL
+                                       CHECK (type IN ('produced', 'declined', 'claimed', 'bought', 'extended',
+                                                       'expired', 'confirmed')),
+                                   actor      TEXT NOT NULL DEFAULT '',
+                                   data       JSONB,
+                                   created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX cart_events_cart_id_idx ON carts.cart_events (cart_id, id);
+CREATE INDEX cart_events_created_at_idx ON carts.cart_events (created_at);
+
+-- the cart history is append only
+CREATE OR REPLACE FUNCTION carts.reject_event_change()
+    RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'cart events can not be changed or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER cart_events_append_only
+    BEFORE UPDATE OR DELETE ON carts.cart_events
+    FOR EACH ROW
+EXECUTE PROCEDURE carts.reject_event_change();
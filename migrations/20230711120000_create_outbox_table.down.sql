@@ -0,0 +1,2 @@
+drop table outbox.messages;
+drop schema outbox;
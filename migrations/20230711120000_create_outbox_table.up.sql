@@ -0,0 +1,19 @@
+CREATE SCHEMA IF NOT EXISTS outbox;
+
+CREATE TABLE outbox.messages (
+                                 id              BIGSERIAL PRIMARY KEY,
+                                 kind            TEXT NOT NULL,
+                                 key             TEXT NOT NULL DEFAULT '',
+                                 payload         JSONB NOT NULL,
+                                 attempts        INTEGER NOT NULL DEFAULT 0,
+                                 last_error      TEXT NOT NULL DEFAULT '',
+                                 next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                 dispatched_at   TIMESTAMPTZ,
+                                 failed_at       TIMESTAMPTZ,
+                                 created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX messages_pending_idx ON outbox.messages (next_attempt_at, id)
+    WHERE dispatched_at IS NULL AND failed_at IS NULL;
+CREATE INDEX messages_key_idx ON outbox.messages (key, id)
+    WHERE dispatched_at IS NULL AND failed_at IS NULL;
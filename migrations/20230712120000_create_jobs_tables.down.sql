@@ -0,0 +1,3 @@
+drop table jobs.schedules;
+drop table jobs.jobs;
+drop schema jobs;
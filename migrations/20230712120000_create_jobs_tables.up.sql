@@ -0,0 +1,33 @@
+CREATE SCHEMA IF NOT EXISTS jobs;
+
+CREATE TABLE jobs.jobs (
+                           id           BIGSERIAL PRIMARY KEY,
+                           kind         TEXT NOT NULL,
+                           payload      JSONB NOT NULL DEFAULT '{}',
+                           status       TEXT NOT NULL DEFAULT 'queued'
+                               CHECK (status IN ('queued', 'running', 'done', 'failed')),
+                           attempts     INTEGER NOT NULL DEFAULT 0,
+                           max_attempts INTEGER NOT NULL DEFAULT 5,
+                           run_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                           locked_at    TIMESTAMPTZ,
+                           last_error   TEXT NOT NULL DEFAULT '',
+                           result       JSONB,
+                           user_id      TEXT NOT NULL DEFAULT '',
+                           finished_at  TIMESTAMPTZ,
+                           created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                           updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX jobs_due_idx ON jobs.jobs (run_at, id) WHERE status IN ('queued', 'running');
+CREATE INDEX jobs_created_at_idx ON jobs.jobs (created_at DESC);
+
+CREATE TRIGGER set_timestamp
+    BEFORE UPDATE ON jobs.jobs
+    FOR EACH ROW
+EXECUTE PROCEDURE trigger_set_timestamp();
+
+CREATE TABLE jobs.schedules (
+                                name        TEXT PRIMARY KEY,
+                                next_run_at TIMESTAMPTZ NOT NULL,
+                                last_run_at TIMESTAMPTZ
+);
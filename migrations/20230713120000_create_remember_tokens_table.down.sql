@@ -0,0 +1 @@
+drop table remember_tokens;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS remember_tokens (
+                                               id             SERIAL PRIMARY KEY,
+                                               user_id        INTEGER NOT NULL,
+                                               remember_token TEXT NOT NULL,
+                                               created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS remember_tokens_token_idx ON remember_tokens (remember_token);
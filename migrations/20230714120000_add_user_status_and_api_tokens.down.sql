@@ -0,0 +1,3 @@
+drop table users.api_tokens;
+
+ALTER TABLE users.users DROP COLUMN status;
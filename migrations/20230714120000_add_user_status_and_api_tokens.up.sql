@@ -0,0 +1,16 @@
+-- users who already exist stay active; new sign ups wait for an operator to approve them
+ALTER TABLE users.users ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+    CHECK (status IN ('pending', 'active', 'disabled'));
+ALTER TABLE users.users ALTER COLUMN status SET DEFAULT 'pending';
+
+CREATE TABLE users.api_tokens (
+                                  id           SERIAL PRIMARY KEY,
+                                  user_id      TEXT NOT NULL REFERENCES users.users (id) ON DELETE CASCADE,
+                                  name         TEXT NOT NULL DEFAULT '',
+                                  token_hash   TEXT NOT NULL UNIQUE,
+                                  last_used_at TIMESTAMPTZ,
+                                  revoked_at   TIMESTAMPTZ,
+                                  created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX api_tokens_user_id_idx ON users.api_tokens (user_id);
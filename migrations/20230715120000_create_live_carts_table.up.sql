@@ -0,0 +1,8 @@
+-- carts being held for a buyer when running without redis; a row is deleted when its hold runs out
+CREATE TABLE carts.live (
+                            id         TEXT PRIMARY KEY,
+                            value      JSONB NOT NULL,
+                            expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX live_expires_at_idx ON carts.live (expires_at);
@@ -0,0 +1 @@
+ALTER TABLE carts.carts DROP COLUMN quantity;
\ No newline at end of file
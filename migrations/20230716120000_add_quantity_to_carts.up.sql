@@ -0,0 +1,7 @@
+-- the number of tickets in a cart, as sent by the extension
+ALTER TABLE carts.carts ADD COLUMN quantity INTEGER NOT NULL DEFAULT 1 CHECK (quantity > 0);
+
+-- carts that were bought already have their quantity on their inventory row
+UPDATE carts.carts c SET quantity = i.quantity FROM inventory.inventory i WHERE i.cart_id = c.id AND i.quantity > 0;
+
+ALTER TABLE carts.carts ALTER COLUMN quantity DROP DEFAULT;
@@ -0,0 +1,10 @@
+// Package migrations embeds the SQL migrations so the binary can apply them itself. Each version
+// has a <version>_<name>.up.sql and a matching .down.sql; see internal/migrate.
+package migrations
+
+import "embed"
+
+// FS holds every migration file
+//
+//go:embed *.sql
+var FS embed.FS